@@ -0,0 +1,211 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/badger"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/bolt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// commands are subcommands of the binary, selected by the first argument (e.g. "app migrate -from badger -to bolt")
+var commands = map[string]func(args []string){
+	"migrate": migrate,
+	"export":  export,
+	"import":  importValues,
+
+	"rotate-key": rotateKey,
+}
+
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configLocation := flags.String("config", "config.json", "configuration file")
+	from := flags.String("from", "", "source backend ("+strings.Join(storage.Backends(), ", ")+")")
+	to := flags.String("to", "", "target backend ("+strings.Join(storage.Backends(), ", ")+")")
+	dryRun := flags.Bool("dry-run", false, "only count the values in the source backend")
+	progress := flags.Int("progress", 1000, "log progress every n values; 0 disables progress output")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		flags.Usage()
+		log.Fatal("missing -from or -to")
+	}
+
+	config, err := configuration.Load(*configLocation)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	result, err := pkg.Migrate(context.Background(), wg, config, *from, *to, *dryRun, *progress)
+	wg.Wait() //wait until both backends are closed
+	if err != nil {
+		log.Fatal("ERROR: migration failed: ", err)
+	}
+	log.Printf("migration finished: source=%v copied=%v verified=%v expired=%v\n", result.Source, result.Copied, result.Verified, result.Expired)
+}
+
+// export writes all last values of the storage selected in the config as newline-delimited json.
+// the service must not run on the same database.
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configLocation := flags.String("config", "config.json", "configuration file")
+	out := flags.String("out", "-", "output file; - writes to stdout")
+	flags.Parse(args)
+
+	var writer io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	err := withStorage(*configLocation, func(store storage.Storage) error {
+		count, err := pkg.Export(store, writer)
+		if err != nil {
+			return fmt.Errorf("export failed after %v values: %w", count, err)
+		}
+		log.Println("exported", count, "values")
+		return nil
+	})
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+}
+
+// importValues reads newline-delimited json as written by export into the storage selected in the config.
+// the service must not run on the same database.
+func importValues(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configLocation := flags.String("config", "config.json", "configuration file")
+	in := flags.String("in", "-", "input file; - reads from stdin")
+	flags.Parse(args)
+
+	var reader io.Reader = os.Stdin
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	err := withStorage(*configLocation, func(store storage.Storage) error {
+		result, err := pkg.Import(store, reader)
+		if err != nil {
+			return fmt.Errorf("import failed after %v values: %w", result.Imported+result.Ignored+result.Expired, err)
+		}
+		log.Println("imported", result.Imported, "values; ignored", result.Ignored, "older and", result.Expired, "expired values")
+		return nil
+	})
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+}
+
+// withStorage opens the storage selected in the config and waits until it is closed after f returned
+func withStorage(configLocation string, f func(store storage.Storage) error) error {
+	config, err := configuration.Load(configLocation)
+	if err != nil {
+		return err
+	}
+	wg := &sync.WaitGroup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	store, err := storage.NewWithConfig(ctx, wg, config)
+	if err == nil {
+		err = f(store)
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// rotateKey changes the encryption key of the storage selected in the config from the configured key to the key in -new-key-file.
+// the service must not run on the same database. afterwards, the new key has to be configured.
+func rotateKey(args []string) {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	configLocation := flags.String("config", "config.json", "configuration file")
+	newKeyFile := flags.String("new-key-file", "", "file containing the new key (16, 24 or 32 bytes; raw, hex or base64 encoded)")
+	decrypt := flags.Bool("decrypt", false, "remove the encryption instead of using a new key")
+	flags.Parse(args)
+
+	if (*newKeyFile == "") == !*decrypt {
+		flags.Usage()
+		log.Fatal("use either -new-key-file or -decrypt")
+	}
+
+	config, err := configuration.Load(*configLocation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	oldKey, err := codec.LoadKey(config.EncryptionKey, config.EncryptionKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var newKey []byte
+	if !*decrypt {
+		newKey, err = codec.LoadKey("", *newKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch backend := storage.Selection(config); backend {
+	case "badger":
+		config, err = storage.ApplySection(config, "badger", config.StorageConfig["badger"])
+		if err != nil {
+			break
+		}
+		if config.BadgerInMemory {
+			err = fmt.Errorf("badger_in_memory databases are not persisted; restart with the new key instead")
+			break
+		}
+		err = badger.RotateKey(config.BadgerLocation, badger.TuningFromConfig(config), oldKey, newKey)
+	case "bolt":
+		err = withStorage(*configLocation, func(store storage.Storage) error {
+			count, err := store.(*bolt.Store).RotateKey(newKey)
+			if err != nil {
+				return err
+			}
+			log.Println("re-encrypted", count, "records")
+			return nil
+		})
+	default:
+		err = fmt.Errorf("storage backend %v does not support encryption", backend)
+	}
+	if err != nil {
+		log.Fatal("ERROR: key rotation failed: ", err)
+	}
+	if *decrypt {
+		log.Println("encryption removed; remove encryption_key and encryption_key_file from the configuration")
+	} else {
+		log.Println("key rotated; configure the new key before the next start")
+	}
+}
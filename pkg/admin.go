@@ -0,0 +1,46 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+)
+
+// backend returns the storage backend behind the index
+func (this *Query) backend() Storage {
+	if this.index != nil {
+		return this.index.Storage
+	}
+	return this.db
+}
+
+func (this *Query) StorageStats() (result model.StorageStats, err error) {
+	provider, ok := this.backend().(storage.StatsProvider)
+	if !ok {
+		return result, model.ErrNotSupported
+	}
+	return provider.Stats()
+}
+
+func (this *Query) Maintain() (result model.MaintenanceResult, err error) {
+	maintainer, ok := this.backend().(storage.Maintainer)
+	if !ok {
+		return result, model.ErrNotSupported
+	}
+	return maintainer.Maintain()
+}
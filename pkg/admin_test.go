@@ -0,0 +1,55 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/memory"
+	"testing"
+)
+
+func TestStorageStats(t *testing.T) {
+	store, err := memory.New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(model.Record{DeviceKey: "d", ServiceKey: "s", Value: []byte("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := KeyValueMapperImpl{}
+	for _, query := range []*Query{NewQuery(mapper, store), NewIndexedQuery(NewIndex(mapper, store, 0))} {
+		stats, err := query.StorageStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Backend != "memory" || stats.Values != 1 {
+			t.Error(stats)
+		}
+		_, err = query.Maintain()
+		if !errors.Is(err, model.ErrNotSupported) {
+			t.Error(err)
+		}
+	}
+
+	_, err = NewQuery(mapper, &StorageMock{}).StorageStats()
+	if !errors.Is(err, model.ErrNotSupported) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+)
+
+func init() {
+	endpoints = append(endpoints, AdminEndpoint)
+}
+
+// AdminEndpoint reports storage statistics (GET /admin/storage) and runs the maintenance of the storage backend
+// (POST /admin/storage/maintenance): the value log gc of badger or the compaction of bolt.
+// the maintenance runs online; the bolt compaction only blocks reads and writes (including the ingest)
+// while it copies the writes made during the compaction and replaces the file. backends without support respond with 501.
+func AdminEndpoint(config configuration.Config, router *httprouter.Router, controller Controller) {
+	router.GET("/admin/storage", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		result, err := controller.StorageStats()
+		if errors.Is(err, model.ErrNotSupported) {
+			http.Error(writer, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+
+	router.POST("/admin/storage/maintenance", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		result, err := controller.Maintain()
+		if errors.Is(err, model.ErrNotSupported) {
+			http.Error(writer, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			log.Println("ERROR: storage", result.Operation, "failed:", err)
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println("storage", result.Operation, "finished in", result.Duration+"; size", result.SizeBefore, "->", result.SizeAfter, "bytes")
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+}
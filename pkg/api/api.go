@@ -22,7 +22,9 @@ import (
 	"fmt"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/api/util"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
 	"github.com/julienschmidt/httprouter"
+	"io"
 	"log"
 	"net/http"
 	"reflect"
@@ -32,19 +34,29 @@ import (
 	"time"
 )
 
-type Getter interface {
-	Get(deviceKey, serviceKey, path string) (value interface{}, time *time.Time, err error)
+type Controller interface {
+	Get(deviceKey, serviceKey, path string) (result model.LastValue, err error)
+	ListDevices(limit int, offset int) (result []model.Device, total int, err error)
+	ListServices(deviceKey string, limit int, offset int) (result []model.Service, total int, err error)
+	DeleteDevice(deviceKey string) (deleted int, err error)
+	DeleteService(deviceKey string, serviceKey string) (deleted bool, err error)
+	History(deviceKey, serviceKey, path string, since time.Time, limit int) (result []model.HistoryValue, err error)
+	Export(writer io.Writer) (count int, err error)
+	Import(reader io.Reader) (result model.ImportResult, err error)
+	StorageStats() (result model.StorageStats, err error)
+	Maintain() (result model.MaintenanceResult, err error)
+	Health() (result model.Health)
 }
 
-var endpoints = []func(config configuration.Config, router *httprouter.Router, getter Getter){}
+var endpoints = []func(config configuration.Config, router *httprouter.Router, controller Controller){}
 
-func Start(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, getter Getter) (err error) {
+func Start(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, controller Controller) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = errors.New(fmt.Sprint(r))
 		}
 	}()
-	router := GetRouter(config, getter)
+	router := GetRouter(config, controller)
 
 	server := &http.Server{Addr: ":" + config.HttpPort, Handler: router}
 	go func() {
@@ -65,11 +77,11 @@ func Start(ctx context.Context, wg *sync.WaitGroup, config configuration.Config,
 	return
 }
 
-func GetRouter(config configuration.Config, getter Getter) http.Handler {
+func GetRouter(config configuration.Config, controller Controller) http.Handler {
 	router := httprouter.New()
 	for _, e := range endpoints {
 		log.Println("add endpoint: " + runtime.FuncForPC(reflect.ValueOf(e).Pointer()).Name())
-		e(config, router, getter)
+		e(config, router, controller)
 	}
 	handler := util.NewCors(router)
 	handler = util.NewLogger(handler)
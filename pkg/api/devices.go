@@ -0,0 +1,130 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	endpoints = append(endpoints, DevicesEndpoint)
+}
+
+// DevicesEndpoint lists devices and their services with last-update timestamps.
+// supports the query parameters limit and offset; the unpaginated count is returned in the X-Total-Count header.
+// DELETE removes the values of a device or a service including their history (404 if nothing was stored);
+// service keys containing '/' are passed unescaped, e.g. DELETE /devices/d/services/a/b removes service "a/b".
+func DevicesEndpoint(config configuration.Config, router *httprouter.Router, controller Controller) {
+	router.GET("/devices", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		limit, offset, err := getPagination(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, total, err := controller.ListDevices(limit, offset)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+
+	router.GET("/devices/:id/services", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		limit, offset, err := getPagination(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, total, err := controller.ListServices(params.ByName("id"), limit, offset)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+
+	router.DELETE("/devices/:id", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		deleted, err := controller.DeleteDevice(params.ByName("id"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(writer, "no values stored for device", http.StatusNotFound)
+			return
+		}
+		if config.Debug {
+			log.Println("DEBUG: deleted", deleted, "values of device", params.ByName("id"))
+		}
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(map[string]int{"deleted": deleted})
+	})
+
+	//service keys may contain '/' (e.g. from {service...} topic templates), so the rest of the path is the service key
+	router.DELETE("/devices/:id/services/*sid", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		serviceKey := strings.TrimPrefix(params.ByName("sid"), "/")
+		if serviceKey == "" {
+			http.Error(writer, "missing service", http.StatusNotFound)
+			return
+		}
+		deleted, err := controller.DeleteService(params.ByName("id"), serviceKey)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(writer, "no value stored for service", http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func getPagination(request *http.Request) (limit int, offset int, err error) {
+	limit, err = getIntQueryParam(request, "limit")
+	if err != nil {
+		return
+	}
+	offset, err = getIntQueryParam(request, "offset")
+	return
+}
+
+func getIntQueryParam(request *http.Request, name string) (result int, err error) {
+	str := request.URL.Query().Get(name)
+	if str == "" {
+		return 0, nil
+	}
+	result, err = strconv.Atoi(str)
+	if err != nil {
+		return 0, errors.New("invalid " + name + " query parameter: " + err.Error())
+	}
+	if result < 0 {
+		return 0, errors.New("invalid " + name + " query parameter: must not be negative")
+	}
+	return result, nil
+}
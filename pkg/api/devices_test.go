@@ -0,0 +1,87 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type ServiceControllerMock struct {
+	Controller
+	services map[string]bool
+}
+
+func (this ServiceControllerMock) DeleteService(deviceKey string, serviceKey string) (deleted bool, err error) {
+	return this.services[deviceKey+"|"+serviceKey], nil
+}
+
+func (this ServiceControllerMock) History(deviceKey, serviceKey, path string, since time.Time, limit int) (result []model.HistoryValue, err error) {
+	if this.services[deviceKey+"|"+serviceKey] {
+		result = append(result, model.HistoryValue{Value: serviceKey})
+	}
+	return result, nil
+}
+
+func TestServiceKeysWithSlash(t *testing.T) {
+	router := GetRouter(configuration.Config{}, ServiceControllerMock{services: map[string]bool{
+		"d|s":               true,
+		"d|a/b":             true,
+		"d|history/history": true,
+	}})
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+
+	for path, expected := range map[string]int{
+		"/devices/d/services/s":       http.StatusNoContent,
+		"/devices/d/services/a/b":     http.StatusNoContent,
+		"/devices/d/services/a%2Fb":   http.StatusNoContent,
+		"/devices/d/services/a":       http.StatusNotFound,
+		"/devices/d/services/":        http.StatusNotFound,
+		"/devices/d/services/unknown": http.StatusNotFound,
+	} {
+		if code := request(http.MethodDelete, path).Code; code != expected {
+			t.Error(path, code, expected)
+		}
+	}
+
+	for path, expected := range map[string]string{
+		"/devices/d/services/s/history":               "s",
+		"/devices/d/services/a/b/history":             "a/b",
+		"/devices/d/services/history/history/history": "history/history",
+	} {
+		recorder := request(http.MethodGet, path)
+		result := []model.HistoryValue{}
+		err := json.NewDecoder(recorder.Body).Decode(&result)
+		if recorder.Code != http.StatusOK || err != nil || len(result) != 1 || result[0].Value != expected {
+			t.Error(path, recorder.Code, err, result)
+		}
+	}
+	for _, path := range []string{"/devices/d/services/s", "/devices/d/services/history", "/devices/d/services/"} {
+		if code := request(http.MethodGet, path).Code; code != http.StatusNotFound {
+			t.Error(path, code)
+		}
+	}
+}
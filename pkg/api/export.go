@@ -0,0 +1,59 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func init() {
+	endpoints = append(endpoints, ExportEndpoint)
+}
+
+// ExportEndpoint streams all last values as newline-delimited json (GET /export)
+// and imports values in the same format (POST /import)
+func ExportEndpoint(config configuration.Config, router *httprouter.Router, controller Controller) {
+	router.GET("/export", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		writer.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		count, err := controller.Export(writer)
+		if err != nil {
+			//the status is already sent; the client sees a truncated stream
+			log.Println("ERROR: export failed after", count, "values:", err)
+			return
+		}
+		if config.Debug {
+			log.Println("DEBUG: exported", count, "values")
+		}
+	})
+
+	router.POST("/import", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		result, err := controller.Import(request.Body)
+		if err != nil {
+			handled := result.Imported + result.Ignored + result.Expired
+			log.Println("ERROR: import failed after", handled, "values:", err)
+			http.Error(writer, "import stopped after "+strconv.Itoa(handled)+" values: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	endpoints = append(endpoints, HistoryEndpoint)
+}
+
+// HistoryEndpoint returns the value history of a device service (requires history_length or history_max_age)
+// at /devices/:id/services/:sid/history; service keys containing '/' are passed unescaped (e.g. /devices/d/services/a/b/history).
+// query parameters:
+//   - path: path in the value (default: whole value)
+//   - since: RFC3339 time or duration relative to now (e.g. 10m)
+//   - limit: max count of returned (newest) values
+func HistoryEndpoint(config configuration.Config, router *httprouter.Router, controller Controller) {
+	//service keys may contain '/' (e.g. from {service...} topic templates), so the service key is everything before "/history"
+	router.GET("/devices/:id/services/*sid", func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		serviceKey, ok := strings.CutSuffix(strings.TrimPrefix(params.ByName("sid"), "/"), "/history")
+		if !ok || serviceKey == "" {
+			http.NotFound(writer, request)
+			return
+		}
+		limit, err := getIntQueryParam(request, "limit")
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since := time.Time{}
+		if sinceStr := request.URL.Query().Get("since"); sinceStr != "" {
+			if duration, err := time.ParseDuration(sinceStr); err == nil {
+				since = time.Now().Add(-duration)
+			} else if since, err = time.Parse(time.RFC3339, sinceStr); err != nil {
+				http.Error(writer, "invalid since query parameter: expect RFC3339 time or duration", http.StatusBadRequest)
+				return
+			}
+		}
+		result, err := controller.History(params.ByName("id"), serviceKey, request.URL.Query().Get("path"), since, limit)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(writer).Encode(result)
+	})
+}
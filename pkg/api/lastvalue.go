@@ -19,8 +19,11 @@ package api
 import (
 	"encoding/json"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
 	"github.com/julienschmidt/httprouter"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -28,10 +31,22 @@ func init() {
 	endpoints = append(endpoints, LastValueEndpoint)
 }
 
-func LastValueEndpoint(config configuration.Config, router *httprouter.Router, getter Getter) {
+func LastValueEndpoint(config configuration.Config, router *httprouter.Router, controller Controller) {
 	resource := "/last-values"
 
+	//failing items get an error and code in their response item instead of failing the whole request;
+	//if at least one item has an error, the response status is 207 (multi-status)
+	//with ?strict=true, items without value (status key-missing or path-missing) get an error with code 404
 	router.POST(resource, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		strict := false
+		if strictStr := request.URL.Query().Get("strict"); strictStr != "" {
+			var err error
+			strict, err = strconv.ParseBool(strictStr)
+			if err != nil {
+				http.Error(writer, "invalid strict query parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		lastValueRequests := []LastValueRequest{}
 		err := json.NewDecoder(request.Body).Decode(&lastValueRequests)
 		if err != nil {
@@ -39,19 +54,41 @@ func LastValueEndpoint(config configuration.Config, router *httprouter.Router, g
 			return
 		}
 		result := make([]LastValueResponse, len(lastValueRequests))
+		partial := false
 		for i, req := range lastValueRequests {
-			var tempTime *time.Time
-			result[i].Value, tempTime, err = getter.Get(req.DeviceId, req.ServiceId, req.ColumnName)
+			value, err := controller.Get(req.DeviceId, req.ServiceId, req.ColumnName)
 			if err != nil {
-				http.Error(writer, err.Error(), http.StatusInternalServerError)
-				return
+				log.Println("ERROR: unable to get last value", req.DeviceId, req.ServiceId, req.ColumnName, err)
+				result[i].Status = model.StatusError
+				result[i].Error = err.Error()
+				result[i].Code = http.StatusInternalServerError
+				partial = true
+				continue
 			}
-			if tempTime != nil {
-				timeStr := tempTime.Format(time.RFC3339)
+			result[i].Value = value.Value
+			result[i].Status = value.Status
+			if value.Time != nil {
+				timeStr := value.Time.Format(time.RFC3339)
 				result[i].Time = &timeStr
 			}
+			if value.Received != nil {
+				receivedStr := value.Received.Format(time.RFC3339)
+				result[i].ReceivedTime = &receivedStr
+			}
+			if value.LastSeen != nil {
+				lastSeenStr := value.LastSeen.Format(time.RFC3339)
+				result[i].LastSeenTime = &lastSeenStr
+			}
+			if strict && value.Status != model.StatusFound {
+				result[i].Error = "not found: " + string(value.Status)
+				result[i].Code = http.StatusNotFound
+				partial = true
+			}
 		}
 		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if partial {
+			writer.WriteHeader(http.StatusMultiStatus)
+		}
 		json.NewEncoder(writer).Encode(result)
 		return
 	})
@@ -65,7 +102,14 @@ type LastValueRequest struct {
 	ColumnName string
 }
 
+// LastValueResponse contains the time of the value (Time), the receive time of the last change of the value (ReceivedTime)
+// and the receive time of the last message, including unchanged values suppressed by write_suppression (LastSeenTime)
 type LastValueResponse struct {
-	Time  *string     `json:"time"`
-	Value interface{} `json:"value"`
+	Time         *string      `json:"time"`
+	Value        interface{}  `json:"value"`
+	ReceivedTime *string      `json:"received_time,omitempty"`
+	LastSeenTime *string      `json:"last_seen_time,omitempty"`
+	Status       model.Status `json:"status"`
+	Error        string       `json:"error,omitempty"`
+	Code         int          `json:"code,omitempty"`
 }
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ControllerMock struct {
+	Controller
+	values map[string]model.LastValue
+}
+
+func (this ControllerMock) Get(deviceKey, serviceKey, path string) (result model.LastValue, err error) {
+	if deviceKey == "corrupt" {
+		return result, errors.New("corrupt record")
+	}
+	result, ok := this.values[deviceKey+"/"+serviceKey+"/"+path]
+	if !ok {
+		result.Status = model.StatusKeyMissing
+	}
+	return result, nil
+}
+
+func TestLastValuePartialErrors(t *testing.T) {
+	now := time.Now()
+	router := GetRouter(configuration.Config{}, ControllerMock{values: map[string]model.LastValue{
+		"d1/s1/": {Value: float64(42), Time: &now, Status: model.StatusFound},
+	}})
+
+	query := func(t *testing.T, query string, body string) (code int, result []LastValueResponse) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/last-values"+query, strings.NewReader(body)))
+		if recorder.Code == http.StatusOK || recorder.Code == http.StatusMultiStatus {
+			err := json.NewDecoder(recorder.Body).Decode(&result)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		return recorder.Code, result
+	}
+
+	t.Run("partial", func(t *testing.T) {
+		code, result := query(t, "", `[{"DeviceId":"d1","ServiceId":"s1"},{"DeviceId":"corrupt","ServiceId":"s1"},{"DeviceId":"d2","ServiceId":"s1"}]`)
+		if code != http.StatusMultiStatus || len(result) != 3 {
+			t.Fatal(code, result)
+		}
+		if result[0].Value != float64(42) || result[0].Status != model.StatusFound || result[0].Error != "" {
+			t.Error(result[0])
+		}
+		if result[1].Status != model.StatusError || result[1].Error == "" || result[1].Code != http.StatusInternalServerError {
+			t.Error(result[1])
+		}
+		if result[2].Status != model.StatusKeyMissing || result[2].Error != "" || result[2].Code != 0 {
+			t.Error(result[2])
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		code, result := query(t, "", `[{"DeviceId":"d1","ServiceId":"s1"},{"DeviceId":"d2","ServiceId":"s1"}]`)
+		if code != http.StatusOK || len(result) != 2 {
+			t.Error(code, result)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		code, result := query(t, "?strict=true", `[{"DeviceId":"d1","ServiceId":"s1"},{"DeviceId":"d2","ServiceId":"s1"}]`)
+		if code != http.StatusMultiStatus || len(result) != 2 {
+			t.Fatal(code, result)
+		}
+		if result[1].Status != model.StatusKeyMissing || result[1].Code != http.StatusNotFound || result[1].Error == "" {
+			t.Error(result[1])
+		}
+	})
+
+	t.Run("invalid strict", func(t *testing.T) {
+		code, _ := query(t, "?strict=foo", `[]`)
+		if code != http.StatusBadRequest {
+			t.Error(code)
+		}
+	})
+}
@@ -28,23 +28,72 @@ import (
 )
 
 type Config struct {
-	MqttPw       string `json:"mqtt_pw"`
+	MqttPw       string `json:"mqtt_pw" secret:"true"`
 	MqttUser     string `json:"mqtt_user"`
 	MqttClientId string `json:"mqtt_client_id"`
 	MqttBroker   string `json:"mqtt_broker"`
 
+	TopicTemplates     []TopicTemplate `json:"topic_templates"`      //empty: "event/{device}/{service}" (raw) and "response/{device}/{service}" (response)
+	ClearTopicTemplate string          `json:"clear_topic_template"` //e.g. "device-removed/{device}"; empty retained messages on matching topics delete the values of the device (or only of {service}); empty disables clearing
+	IngestWorkers      int64           `json:"ingest_workers"`
+
 	BadgerLocation   string `json:"badger_location"`
 	BadgerGcInterval string `json:"badger_gc_interval"`
-	BadgerTtl        string `json:"badger_ttl"`
+	BadgerTtl        string `json:"badger_ttl"` //deprecated: used as ttl if ttl is not set
+
+	BadgerProfile          string `json:"badger_profile"`             //"" (badger defaults) or "low_memory"; the following badger options override the profile if set
+	BadgerMemTableSize     int64  `json:"badger_mem_table_size"`      //bytes
+	BadgerValueLogFileSize int64  `json:"badger_value_log_file_size"` //bytes; between 1MB and 2GB
+	BadgerBlockCacheSize   int64  `json:"badger_block_cache_size"`    //bytes
+	BadgerIndexCacheSize   int64  `json:"badger_index_cache_size"`    //bytes; without encryption, 0 keeps all indexes in memory
+	BadgerCompression      string `json:"badger_compression"`         //"none", "snappy" or "zstd"; block compression of badger, independent of compression
+	BadgerNumCompactors    int64  `json:"badger_num_compactors"`      //at least 2
+	BadgerInMemory         bool   `json:"badger_in_memory"`           //keeps the database in memory only; badger_location is ignored
+
+	BoltLocation            string `json:"bolt_location"`
+	BoltTtlSweepInterval    string `json:"bolt_ttl_sweep_interval"`
+	BoltWriteBufferInterval string `json:"bolt_write_buffer_interval"` //empty disables the write buffer
+	BoltWriteBufferSize     int64  `json:"bolt_write_buffer_size"`     //number of buffered writes that triggers a flush before the interval
+
+	Ttl string `json:"ttl"` //badger: values expire ttl after their last write; bolt: values expire ttl after they were last seen; empty disables expiry
+
+	MemorySnapshotLocation string `json:"memory_snapshot_location"`
+	MemorySnapshotInterval string `json:"memory_snapshot_interval"`
+
+	Compression          string `json:"compression"`           //"", "gzip" or "zstd"; compresses stored payloads of bolt and badger
+	CompressionThreshold int64  `json:"compression_threshold"` //payloads up to this size in bytes are stored uncompressed
+
+	EncryptionKey     string `json:"encryption_key" secret:"true"` //hex or base64 encoded AES key (16, 24 or 32 bytes); prefer encryption_key_file or the ENCRYPTION_KEY environment variable
+	EncryptionKeyFile string `json:"encryption_key_file"`          //file containing the encryption key; alternative to encryption_key
+
+	HistoryLength int64  `json:"history_length"`
+	HistoryMaxAge string `json:"history_max_age"`
 
-	BoltLocation string `json:"bolt_location"`
+	IndexOnIngest bool  `json:"index_on_ingest"`
+	IndexSize     int64 `json:"index_size"` //max number of indexed device services; least recently used entries are evicted (<= 0: 10000)
 
-	StorageSelection string `json:"storage_selection"`
+	WriteSuppression string  `json:"write_suppression"` //"", "identical" or "deadband"; suppressed values only refresh the last seen time
+	DeadbandAbsolute float64 `json:"deadband_absolute"` //max absolute change of numbers in "deadband" mode
+	DeadbandRelative float64 `json:"deadband_relative"` //max change of numbers relative to the stored number in "deadband" mode (e.g. 0.01 for 1%)
+
+	StorageSelection string                     `json:"storage_selection"` //backend name or "auto" (keeps an existing database, otherwise chooses by available memory and disk space)
+	StorageConfig    map[string]json.RawMessage `json:"storage_config"`    //backend specific config sections, keyed by backend name; built-in backends accept their flat keys without prefix, e.g. {"bolt": {"location": "./last_value.db"}}
+
+	StorageVerify          bool   `json:"storage_verify"`           //checks bolt and badger databases on start; failed checks count as corruption
+	StorageRecovery        bool   `json:"storage_recovery"`         //moves corrupt databases aside and starts with a fresh database instead of failing
+	StorageRecoveryRestore string `json:"storage_recovery_restore"` //export file, or directory of export files of which the latest is used, imported after a recovery
 
 	HttpPort string `json:"http_port"`
 	Debug    bool   `json:"debug"`
 }
 
+type TopicTemplate struct {
+	Template   string `json:"template"`
+	Payload    string `json:"payload"`     //"raw" or "response" (json with the value as string in the "data" field)
+	TimePath   string `json:"time_path"`   //optional path of the value timestamp in the payload; if empty, the receive time is used
+	TimeFormat string `json:"time_format"` //"rfc3339", "unix", "unix_ms" or "unix_ns"; if empty, strings are parsed as rfc3339 and numbers as unix seconds
+}
+
 //loads config from json in location and used environment variables (e.g ZookeeperUrl --> ZOOKEEPER_URL)
 func Load(location string) (config Config, err error) {
 	file, error := os.Open(location)
@@ -59,6 +108,10 @@ func Load(location string) (config Config, err error) {
 		return config, error
 	}
 	handleEnvironmentVars(&config)
+	if config.Ttl == "" && config.BadgerTtl != "" {
+		log.Println("WARNING: badger_ttl is deprecated, use ttl")
+		config.Ttl = config.BadgerTtl
+	}
 	return config, nil
 }
 
@@ -77,7 +130,7 @@ func fieldNameToEnvName(s string) string {
 	return strings.ToUpper(strings.Join(a, "_"))
 }
 
-// preparations for docker
+// preparations for docker; values of fields tagged with secret:"true" are not logged
 func handleEnvironmentVars(config *Config) {
 	configValue := reflect.Indirect(reflect.ValueOf(config))
 	configType := configValue.Type()
@@ -86,7 +139,11 @@ func handleEnvironmentVars(config *Config) {
 		envName := fieldNameToEnvName(fieldName)
 		envValue := os.Getenv(envName)
 		if envValue != "" {
-			fmt.Println("use environment variable: ", envName, " = ", envValue)
+			loggedValue := envValue
+			if configType.Field(index).Tag.Get("secret") == "true" {
+				loggedValue = "***"
+			}
+			fmt.Println("use environment variable: ", envName, " = ", loggedValue)
 			if configValue.FieldByName(fieldName).Kind() == reflect.Int64 {
 				i, _ := strconv.ParseInt(envValue, 10, 64)
 				configValue.FieldByName(fieldName).SetInt(i)
@@ -102,14 +159,32 @@ func handleEnvironmentVars(config *Config) {
 				f, _ := strconv.ParseFloat(envValue, 64)
 				configValue.FieldByName(fieldName).SetFloat(f)
 			}
-			if configValue.FieldByName(fieldName).Kind() == reflect.Slice {
+			if configValue.FieldByName(fieldName).Kind() == reflect.Slice && configValue.FieldByName(fieldName).Type().Elem().Kind() != reflect.String {
+				//complex lists are expected as json
+				value := reflect.New(configValue.FieldByName(fieldName).Type())
+				err := json.Unmarshal([]byte(envValue), value.Interface())
+				if err != nil {
+					log.Println("WARNING: unable to parse environment variable as json:", envName, err)
+				} else {
+					configValue.FieldByName(fieldName).Set(value.Elem())
+				}
+			} else if configValue.FieldByName(fieldName).Kind() == reflect.Slice {
 				val := []string{}
 				for _, element := range strings.Split(envValue, ",") {
 					val = append(val, strings.TrimSpace(element))
 				}
 				configValue.FieldByName(fieldName).Set(reflect.ValueOf(val))
 			}
-			if configValue.FieldByName(fieldName).Kind() == reflect.Map {
+			if configValue.FieldByName(fieldName).Kind() == reflect.Map && configValue.FieldByName(fieldName).Type().Elem().Kind() != reflect.String {
+				//complex maps are expected as json
+				value := reflect.New(configValue.FieldByName(fieldName).Type())
+				err := json.Unmarshal([]byte(envValue), value.Interface())
+				if err != nil {
+					log.Println("WARNING: unable to parse environment variable as json:", envName, err)
+				} else {
+					configValue.FieldByName(fieldName).Set(value.Elem())
+				}
+			} else if configValue.FieldByName(fieldName).Kind() == reflect.Map {
 				value := map[string]string{}
 				for _, element := range strings.Split(envValue, ",") {
 					keyVal := strings.Split(element, ":")
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"sort"
+)
+
+// ListDevices returns the devices with stored values sorted by id; payloads are not read, paginated by limit and offset (limit <= 0 --> no limit)
+func (this *Query) ListDevices(limit int, offset int) (result []model.Device, total int, err error) {
+	devices := map[string]*model.Device{}
+	err = this.db.ScanTimes("", func(record model.Record) error {
+		device, ok := devices[record.DeviceKey]
+		if !ok {
+			device = &model.Device{Id: record.DeviceKey}
+			devices[record.DeviceKey] = device
+		}
+		device.ServiceCount++
+		if record.Time.After(device.LastUpdate) {
+			device.LastUpdate = record.Time
+		}
+		return nil
+	})
+	if err != nil {
+		return result, total, err
+	}
+	result = []model.Device{}
+	for _, device := range devices {
+		result = append(result, *device)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return paginate(result, limit, offset), len(result), nil
+}
+
+// ListServices returns the services of a device with stored values sorted by id, paginated by limit and offset (limit <= 0 --> no limit)
+func (this *Query) ListServices(deviceKey string, limit int, offset int) (result []model.Service, total int, err error) {
+	result = []model.Service{}
+	err = this.db.ScanTimes(deviceKey, func(record model.Record) error {
+		result = append(result, model.Service{Id: record.ServiceKey, LastUpdate: record.Time})
+		return nil
+	})
+	if err != nil {
+		return result, total, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return paginate(result, limit, offset), len(result), nil
+}
+
+// DeleteDevice removes all values of the device and their history; deleted is the number of removed values
+func (this *Query) DeleteDevice(deviceKey string) (deleted int, err error) {
+	return this.db.DeletePrefix(deviceKey)
+}
+
+// DeleteService removes the value of the device service and its history; deleted is false if no value was stored
+func (this *Query) DeleteService(deviceKey string, serviceKey string) (deleted bool, err error) {
+	return this.db.Delete(deviceKey, serviceKey)
+}
+
+func paginate[T any](list []T, limit int, offset int) []T {
+	if offset >= len(list) {
+		return []T{}
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
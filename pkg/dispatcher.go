@@ -0,0 +1,78 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// KeyDispatcher runs tasks with the same key sequentially in the order of their Dispatch calls,
+// while tasks with different keys may run concurrently on one of the workers.
+// when ctx is done, the workers run all queued tasks before they stop.
+type KeyDispatcher struct {
+	mux    sync.RWMutex //guards closed against Dispatch calls
+	closed bool
+	queues []chan func()
+}
+
+// NewKeyDispatcher starts the workers; wg (may be nil) is done when all workers stopped after ctx is done
+func NewKeyDispatcher(ctx context.Context, wg *sync.WaitGroup, workers int, bufferSize int) *KeyDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	result := &KeyDispatcher{}
+	for i := 0; i < workers; i++ {
+		queue := make(chan func(), bufferSize)
+		result.queues = append(result.queues, queue)
+		if wg != nil {
+			wg.Add(1)
+		}
+		go func() {
+			if wg != nil {
+				defer wg.Done()
+			}
+			for task := range queue {
+				task()
+			}
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		//waits for running Dispatch calls, which are not blocked for long because the workers keep running
+		result.mux.Lock()
+		defer result.mux.Unlock()
+		result.closed = true
+		for _, queue := range result.queues {
+			close(queue)
+		}
+	}()
+	return result
+}
+
+// Dispatch blocks if the queue of the key is full; tasks dispatched after ctx is done are dropped
+func (this *KeyDispatcher) Dispatch(key string, task func()) {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	this.mux.RLock()
+	defer this.mux.RUnlock()
+	if this.closed {
+		return
+	}
+	this.queues[hash.Sum32()%uint32(len(this.queues))] <- task
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyDispatcherOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcher := NewKeyDispatcher(ctx, nil, 4, 10)
+
+	mux := sync.Mutex{}
+	results := map[string][]int{}
+	done := sync.WaitGroup{}
+	for i := 0; i < 1000; i++ {
+		key := "key_" + strconv.Itoa(i%7)
+		done.Add(1)
+		dispatcher.Dispatch(key, func() {
+			defer done.Done()
+			mux.Lock()
+			defer mux.Unlock()
+			results[key] = append(results[key], i)
+		})
+	}
+	done.Wait()
+	for key, list := range results {
+		for i := 1; i < len(list); i++ {
+			if list[i-1] >= list[i] {
+				t.Error("unexpected order", key, list)
+				break
+			}
+		}
+	}
+}
+
+func TestKeyDispatcherShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	dispatcher := NewKeyDispatcher(ctx, wg, 2, 100)
+
+	count := atomic.Int64{}
+	for i := 0; i < 100; i++ {
+		dispatcher.Dispatch("key_"+strconv.Itoa(i%3), func() {
+			time.Sleep(time.Millisecond)
+			count.Add(1)
+		})
+	}
+	cancel()
+	wg.Wait()
+	if count.Load() != 100 {
+		t.Error("queued tasks not run before shutdown", count.Load())
+	}
+
+	dispatcher.Dispatch("key_0", func() {
+		count.Add(1)
+	})
+	time.Sleep(10 * time.Millisecond)
+	if count.Load() != 100 {
+		t.Error("task dispatched after shutdown should be dropped")
+	}
+}
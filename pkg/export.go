@@ -0,0 +1,164 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"io"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// ExportRecord is one line of the newline-delimited json export format.
+// payloads that are valid utf-8 are exported as string in Payload, other payloads base64 encoded in PayloadBase64.
+type ExportRecord struct {
+	Device        string    `json:"device"`
+	Service       string    `json:"service"`
+	Payload       *string   `json:"payload,omitempty"`
+	PayloadBase64 []byte    `json:"payload_base64,omitempty"`
+	Time          time.Time `json:"time"`
+	Received      time.Time `json:"received"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+func newExportRecord(record model.Record) ExportRecord {
+	result := ExportRecord{
+		Device:   record.DeviceKey,
+		Service:  record.ServiceKey,
+		Time:     record.Time,
+		Received: record.Received,
+		LastSeen: record.LastSeen,
+	}
+	if utf8.Valid(record.Value) {
+		payload := string(record.Value)
+		result.Payload = &payload
+	} else {
+		result.PayloadBase64 = record.Value
+	}
+	return result
+}
+
+func (this ExportRecord) record() (result model.Record, err error) {
+	if this.Device == "" || this.Service == "" {
+		return result, errors.New("missing device or service")
+	}
+	if this.Time.IsZero() {
+		return result, errors.New("missing time")
+	}
+	result = model.Record{
+		DeviceKey:  this.Device,
+		ServiceKey: this.Service,
+		Value:      this.PayloadBase64,
+		Time:       this.Time,
+		Received:   this.Received,
+		LastSeen:   this.LastSeen,
+	}
+	if this.Payload != nil {
+		result.Value = []byte(*this.Payload)
+	}
+	if result.Received.IsZero() {
+		result.Received = result.Time
+	}
+	return result, nil
+}
+
+// Export writes all last values as newline-delimited json.
+// values are read device by device and written after each read, so a slow writer does not keep a read transaction
+// of the storage open. values that can not be decoded are skipped (see Storage.Scan).
+func Export(store Storage, writer io.Writer) (count int, err error) {
+	devices := []string{}
+	known := map[string]bool{}
+	err = store.ScanTimes("", func(record model.Record) error {
+		if !known[record.DeviceKey] {
+			known[record.DeviceKey] = true
+			devices = append(devices, record.DeviceKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	sort.Strings(devices)
+	buffer := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(buffer)
+	for _, device := range devices {
+		records := []model.Record{}
+		err = store.Scan(device, func(record model.Record) error {
+			records = append(records, record)
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, record := range records {
+			err = encoder.Encode(newExportRecord(record))
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, buffer.Flush()
+}
+
+// Import reads newline-delimited json as written by Export.
+// imported values replace stored values only if they are not older, so an import does not overwrite newer values.
+// values the storage would expire right away are skipped (see Expirer).
+// on invalid lines, the import stops with an error; values of previous lines remain stored.
+func Import(store Storage, reader io.Reader) (result model.ImportResult, err error) {
+	decoder := json.NewDecoder(reader)
+	for line := 1; ; line++ {
+		exportRecord := ExportRecord{}
+		err = decoder.Decode(&exportRecord)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("unable to decode record %v: %w", line, err)
+		}
+		record, err := exportRecord.record()
+		if err != nil {
+			return result, fmt.Errorf("invalid record %v: %w", line, err)
+		}
+		if expired(store, record) {
+			result.Expired++
+			continue
+		}
+		stored, err := store.SetIfNewer(record)
+		if err != nil {
+			return result, fmt.Errorf("unable to store record %v: %w", line, err)
+		}
+		if stored {
+			result.Imported++
+		} else {
+			result.Ignored++
+		}
+	}
+}
+
+func (this *Query) Export(writer io.Writer) (count int, err error) {
+	return Export(this.db, writer)
+}
+
+func (this *Query) Import(reader io.Reader) (result model.ImportResult, err error) {
+	return Import(this.db, reader)
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/bolt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/memory"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	source, err := memory.New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valueTime := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	receivedTime := time.Now().Truncate(time.Millisecond)
+	records := []model.Record{
+		{DeviceKey: "d1", ServiceKey: "s1", Value: []byte(`{"value":42}`), Time: valueTime, Received: receivedTime},
+		{DeviceKey: "d1", ServiceKey: "s2", Value: []byte{0xff, 0x00, 0x01}, Time: valueTime, Received: receivedTime},
+		{DeviceKey: "d.2", ServiceKey: "s.1", Value: []byte("text"), Time: valueTime, Received: receivedTime},
+	}
+	for _, record := range records {
+		err = source.Set(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	count, err := Export(source, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || strings.Count(buffer.String(), "\n") != 3 {
+		t.Error(count, buffer.String())
+	}
+
+	target, err := memory.New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := model.Record{DeviceKey: "d1", ServiceKey: "s1", Value: []byte("newer"), Time: time.Now(), Received: time.Now()}
+	err = target.Set(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Import(target, bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 2 || result.Ignored != 1 {
+		t.Error(result)
+	}
+	for _, expected := range append(records[1:], newer) {
+		actual, found, err := target.Get(expected.DeviceKey, expected.ServiceKey)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !found || !bytes.Equal(actual.Value, expected.Value) || !actual.Time.Equal(expected.Time) || !actual.Received.Equal(expected.Received) {
+			t.Error(found, actual, expected)
+		}
+	}
+
+	_, err = Import(target, strings.NewReader(`{"device":"d","service":"s","payload":"1","time":"2024-01-01T00:00:00Z"}`+"\n"+`{"device":"d"}`))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Error(err)
+	}
+}
+
+func TestImportExpired(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target, err := bolt.New(ctx, wg, t.TempDir()+"/bolt.db", false, "1h", "1h", "", 0, "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	input := `{"device":"d1","service":"s1","payload":"1","time":"` + old.Format(time.RFC3339Nano) + `","received":"` + old.Format(time.RFC3339Nano) + `"}` + "\n" +
+		`{"device":"d2","service":"s1","payload":"2","time":"` + time.Now().Format(time.RFC3339Nano) + `"}` + "\n"
+	result, err := Import(target, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 || result.Expired != 1 {
+		t.Error(result)
+	}
+	_, found, err := target.Get("d1", "s1")
+	if err != nil || found {
+		t.Error(found, err)
+	}
+	_, found, err = target.Get("d2", "s1")
+	if err != nil || !found {
+		t.Error(found, err)
+	}
+}
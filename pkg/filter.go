@@ -0,0 +1,176 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"math"
+	"reflect"
+)
+
+const (
+	SuppressNone      = ""
+	SuppressIdentical = "identical" //skip byte-identical payloads
+	SuppressDeadband  = "deadband"  //skip payloads with the same paths where all numbers are within the deadband and all other values are equal
+)
+
+// WriteFilter decides in the ingest path if a value is stored or only refreshes the last seen time of the stored value
+type WriteFilter struct {
+	mode     string
+	absolute float64
+	relative float64
+	mapper   KeyValueMapper
+}
+
+func NewWriteFilter(config configuration.Config, mapper KeyValueMapper) (*WriteFilter, error) {
+	switch config.WriteSuppression {
+	case SuppressNone, SuppressIdentical, SuppressDeadband:
+	default:
+		return nil, errors.New("unknown write_suppression " + config.WriteSuppression)
+	}
+	if config.DeadbandAbsolute < 0 || config.DeadbandRelative < 0 {
+		return nil, errors.New("deadband_absolute and deadband_relative must not be negative")
+	}
+	return &WriteFilter{
+		mode:     config.WriteSuppression,
+		absolute: config.DeadbandAbsolute,
+		relative: config.DeadbandRelative,
+		mapper:   mapper,
+	}, nil
+}
+
+func (this *WriteFilter) Enabled() bool {
+	return this.mode != SuppressNone
+}
+
+// Suppress returns true if record does not change the current value.
+// ignorePath is excluded from the deadband comparison (e.g. the time path of the topic template).
+// values older than the current value are never suppressed, so they reach the history.
+func (this *WriteFilter) Suppress(current model.Record, record model.Record, ignorePath string) bool {
+	if !this.Enabled() || record.Time.Before(current.Time) {
+		return false
+	}
+	if bytes.Equal(current.Value, record.Value) {
+		return true
+	}
+	if this.mode != SuppressDeadband {
+		return false
+	}
+	currentPaths := this.mapper.Get(current.Value)
+	paths := this.mapper.Get(record.Value)
+	if len(currentPaths) != len(paths) {
+		return false
+	}
+	for path, value := range paths {
+		currentValue, ok := currentPaths[path]
+		if !ok {
+			return false
+		}
+		if path == ignorePath {
+			continue
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			continue //the content is compared by its own paths
+		}
+		if within, isNumber := this.numbersWithinDeadband(currentValue, value); isNumber {
+			if !within {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(currentValue, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// numbersWithinDeadband compares current and value if both are numbers (isNumber == false otherwise).
+// integers are compared exactly, because float64 can not represent all json.Number integers above 2^53 (e.g. counters).
+func (this *WriteFilter) numbersWithinDeadband(current interface{}, value interface{}) (within bool, isNumber bool) {
+	currentLiteral, currentIsLiteral := current.(json.Number)
+	literal, isLiteral := value.(json.Number)
+	if currentIsLiteral && isLiteral {
+		if currentLiteral == literal {
+			return true, true
+		}
+		currentInteger, currentErr := currentLiteral.Int64()
+		integer, err := literal.Int64()
+		if currentErr == nil && err == nil {
+			return this.withinIntegerDeadband(currentInteger, integer), true
+		}
+	}
+	currentNumber, currentIsNumber := toFloat(current)
+	number, isNumber := toFloat(value)
+	if !currentIsNumber || !isNumber {
+		return false, false
+	}
+	return this.withinDeadband(currentNumber, number), true
+}
+
+// withinIntegerDeadband is withinDeadband for integers; the difference and the absolute deadband are compared without rounding
+func (this *WriteFilter) withinIntegerDeadband(current int64, value int64) bool {
+	var diff uint64 //the difference of two int64 always fits
+	if value >= current {
+		diff = uint64(value) - uint64(current)
+	} else {
+		diff = uint64(current) - uint64(value)
+	}
+	if this.absolute == 0 && this.relative == 0 {
+		return diff == 0
+	}
+	//diff is an integer, so it exceeds the deadband if it exceeds the integer part of the deadband
+	if this.absolute > 0 && this.absolute < math.MaxUint64 && diff > uint64(this.absolute) {
+		return false
+	}
+	if this.relative > 0 && float64(diff) > this.relative*math.Abs(float64(current)) {
+		return false
+	}
+	return true
+}
+
+// withinDeadband is true if the change is within every configured deadband;
+// without configured deadband, only equal numbers are within
+func (this *WriteFilter) withinDeadband(current float64, value float64) bool {
+	diff := math.Abs(value - current)
+	if this.absolute == 0 && this.relative == 0 {
+		return diff == 0
+	}
+	if this.absolute > 0 && diff > this.absolute {
+		return false
+	}
+	if this.relative > 0 && diff > this.relative*math.Abs(current) {
+		return false
+	}
+	return true
+}
+
+func toFloat(value interface{}) (result float64, ok bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestWriteFilter(t *testing.T) {
+	now := time.Now()
+	record := func(payload string, t time.Time) model.Record {
+		return model.Record{DeviceKey: "d", ServiceKey: "s", Value: []byte(payload), Time: t, Received: t}
+	}
+	current := record(`{"value":100,"unit":"W","time":1700000000}`, now)
+
+	tests := []struct {
+		name       string
+		config     configuration.Config
+		payload    string
+		time       time.Time
+		ignorePath string
+		expected   bool
+	}{
+		{name: "disabled", payload: `{"value":100,"unit":"W","time":1700000000}`, time: now, expected: false},
+		{name: "identical", config: configuration.Config{WriteSuppression: SuppressIdentical}, payload: `{"value":100,"unit":"W","time":1700000000}`, time: now.Add(time.Second), expected: true},
+		{name: "identical older", config: configuration.Config{WriteSuppression: SuppressIdentical}, payload: `{"value":100,"unit":"W","time":1700000000}`, time: now.Add(-time.Second), expected: false},
+		{name: "identical changed", config: configuration.Config{WriteSuppression: SuppressIdentical}, payload: `{"value":100.5,"unit":"W","time":1700000000}`, time: now, expected: false},
+		{name: "deadband without bounds", config: configuration.Config{WriteSuppression: SuppressDeadband}, payload: `{"unit":"W","value":100.0,"time":1700000000}`, time: now, expected: true},
+		{name: "deadband absolute", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":100.5,"unit":"W","time":1700000000}`, time: now, expected: true},
+		{name: "deadband absolute exceeded", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":102,"unit":"W","time":1700000000}`, time: now, expected: false},
+		{name: "deadband relative", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandRelative: 0.05}, payload: `{"value":104,"unit":"W","time":1700000000}`, time: now, expected: true},
+		{name: "deadband both", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1, DeadbandRelative: 0.05}, payload: `{"value":104,"unit":"W","time":1700000000}`, time: now, expected: false},
+		{name: "deadband string changed", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":100,"unit":"kW","time":1700000000}`, time: now, expected: false},
+		{name: "deadband path added", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":100,"unit":"W","time":1700000000,"foo":1}`, time: now, expected: false},
+		{name: "deadband time path changed", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":100,"unit":"W","time":1700000060}`, time: now, expected: false},
+		{name: "deadband time path ignored", config: configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: 1}, payload: `{"value":100,"unit":"W","time":1700000060}`, time: now, ignorePath: "time", expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := NewWriteFilter(test.config, KeyValueMapperImpl{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result := filter.Suppress(current, record(test.payload, test.time), test.ignorePath); result != test.expected {
+				t.Error(result, test.expected)
+			}
+		})
+	}
+
+	_, err := NewWriteFilter(configuration.Config{WriteSuppression: "unknown"}, KeyValueMapperImpl{})
+	if err == nil {
+		t.Error("expected error for unknown write_suppression")
+	}
+}
+
+func TestWriteFilterLargeIntegers(t *testing.T) {
+	now := time.Now()
+	record := func(payload string) model.Record {
+		return model.Record{DeviceKey: "d", ServiceKey: "s", Value: []byte(payload), Time: now, Received: now}
+	}
+	current := record(`{"counter":9007199254740992}`) //2^53, the next integer is not representable as float64
+
+	tests := []struct {
+		name     string
+		absolute float64
+		current  string
+		payload  string
+		expected bool
+	}{
+		{name: "equal", payload: `{"counter": 9007199254740992}`, expected: true},
+		{name: "increment", payload: `{"counter":9007199254740993}`, expected: false},
+		{name: "increment within absolute", absolute: 1, payload: `{"counter":9007199254740993}`, expected: true},
+		{name: "increment exceeds absolute", absolute: 1.5, payload: `{"counter":9007199254740994}`, expected: false},
+		{name: "decimal", payload: `{"counter":9007199254740992.0}`, expected: true},
+		{name: "full range", absolute: 1, current: `{"counter":-9223372036854775808}`, payload: `{"counter":9223372036854775807}`, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := NewWriteFilter(configuration.Config{WriteSuppression: SuppressDeadband, DeadbandAbsolute: test.absolute}, KeyValueMapperImpl{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			currentRecord := current
+			if test.current != "" {
+				currentRecord = record(test.current)
+			}
+			if result := filter.Suppress(currentRecord, record(test.payload), ""); result != test.expected {
+				t.Error(result, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"time"
+)
+
+// History returns the history of a device, service and path since the given time in chronological order.
+// entries in which the path does not exist are skipped. if limit > 0, only the newest limit entries are returned.
+func (this *Query) History(deviceKey, serviceKey, path string, since time.Time, limit int) (result []model.HistoryValue, err error) {
+	records, err := this.db.History(deviceKey, serviceKey, since, 0)
+	if err != nil {
+		return result, err
+	}
+	result = []model.HistoryValue{}
+	for _, record := range records {
+		value, ok := this.mapper.Get(record.Value)[path]
+		if ok {
+			result = append(result, model.HistoryValue{Time: record.Time, Value: value})
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
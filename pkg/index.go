@@ -0,0 +1,171 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"container/list"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"sync"
+	"time"
+)
+
+// Index wraps a Storage and keeps the flattened paths of the latest payloads in memory.
+// paths are computed on ingest (Set) and on reads of values that are not indexed, so reads are map lookups
+// instead of unmarshalling and walking the payload; the value itself is still read from the Storage.
+// entries are validated against the time and receive time of the stored value, so values written by other means
+// are never served stale. at most size entries are kept; the least recently used entries are evicted.
+type Index struct {
+	Storage
+	mapper  KeyValueMapper
+	size    int
+	mux     sync.Mutex
+	entries map[indexKey]*list.Element
+	lru     *list.List //of *indexEntry, most recently used first
+}
+
+// DefaultIndexSize is used for sizes <= 0
+const DefaultIndexSize = 10000
+
+type indexKey struct {
+	deviceKey  string
+	serviceKey string
+}
+
+type indexEntry struct {
+	key      indexKey
+	time     time.Time
+	received time.Time
+	paths    map[string]interface{}
+}
+
+func NewIndex(mapper KeyValueMapper, db Storage, size int) *Index {
+	if size <= 0 {
+		size = DefaultIndexSize
+	}
+	return &Index{
+		Storage: db,
+		mapper:  mapper,
+		size:    size,
+		entries: map[indexKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Expired forwards to the storage (see Expirer)
+func (this *Index) Expired(record model.Record) bool {
+	return expired(this.Storage, record)
+}
+
+func (this *Index) Set(record model.Record) error {
+	err := this.Storage.Set(record)
+	if err != nil {
+		return err
+	}
+	this.put(record)
+	return nil
+}
+
+func (this *Index) SetIfNewer(record model.Record) (stored bool, err error) {
+	stored, err = this.Storage.SetIfNewer(record)
+	if err != nil || !stored {
+		return stored, err
+	}
+	this.put(record)
+	return stored, nil
+}
+
+func (this *Index) Delete(deviceKey string, serviceKey string) (deleted bool, err error) {
+	deleted, err = this.Storage.Delete(deviceKey, serviceKey)
+	this.remove(func(key indexKey) bool {
+		return key.deviceKey == deviceKey && key.serviceKey == serviceKey
+	})
+	return deleted, err
+}
+
+func (this *Index) DeletePrefix(deviceKey string) (deleted int, err error) {
+	deleted, err = this.Storage.DeletePrefix(deviceKey)
+	this.remove(func(key indexKey) bool {
+		return key.deviceKey == deviceKey
+	})
+	return deleted, err
+}
+
+// Paths returns the flattened paths of the record value
+func (this *Index) Paths(record model.Record) map[string]interface{} {
+	key := indexKey{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}
+	this.mux.Lock()
+	element, ok := this.entries[key]
+	if ok {
+		entry := element.Value.(*indexEntry)
+		if entry.time.Equal(record.Time) && entry.received.Equal(receivedTime(record)) {
+			this.lru.MoveToFront(element)
+			this.mux.Unlock()
+			return entry.paths
+		}
+	}
+	this.mux.Unlock()
+	return this.put(record)
+}
+
+// forget removes the entry of a value that is no longer stored (e.g. expired)
+func (this *Index) forget(deviceKey string, serviceKey string) {
+	this.remove(func(key indexKey) bool {
+		return key.deviceKey == deviceKey && key.serviceKey == serviceKey
+	})
+}
+
+func (this *Index) put(record model.Record) map[string]interface{} {
+	entry := &indexEntry{
+		key:      indexKey{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey},
+		time:     record.Time,
+		received: receivedTime(record),
+		paths:    this.mapper.Get(record.Value),
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if element, ok := this.entries[entry.key]; ok {
+		element.Value = entry
+		this.lru.MoveToFront(element)
+		return entry.paths
+	}
+	this.entries[entry.key] = this.lru.PushFront(entry)
+	for this.lru.Len() > this.size {
+		oldest := this.lru.Back()
+		this.lru.Remove(oldest)
+		delete(this.entries, oldest.Value.(*indexEntry).key)
+	}
+	return entry.paths
+}
+
+func (this *Index) remove(match func(key indexKey) bool) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for key, element := range this.entries {
+		if match(key) {
+			this.lru.Remove(element)
+			delete(this.entries, key)
+		}
+	}
+}
+
+// receivedTime returns the receive time as read back from storages, which use the value time for missing receive times
+func receivedTime(record model.Record) time.Time {
+	if record.Received.IsZero() {
+		return record.Time
+	}
+	return record.Received
+}
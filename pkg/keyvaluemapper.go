@@ -17,7 +17,10 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"strconv"
 	"strings"
@@ -27,9 +30,10 @@ type KeyValueMapperImpl struct {
 	Debug bool
 }
 
+// Get returns every path of the json message with its value. numbers are returned as json.Number
+// to preserve the original literal (e.g. int64 counters above 2^53 or high precision decimals).
 func (this KeyValueMapperImpl) Get(message []byte) (result map[string]interface{}) {
-	var value interface{}
-	err := json.Unmarshal(message, &value)
+	value, err := decodeJson(message)
 	if err != nil {
 		if this.Debug {
 			log.Println("WARNING: message is not json --> return {\"\":message}")
@@ -39,6 +43,19 @@ func (this KeyValueMapperImpl) Get(message []byte) (result map[string]interface{
 	return this.walk([]string{}, value)
 }
 
+func decodeJson(message []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after json value")
+	}
+	return value, nil
+}
+
 func (this KeyValueMapperImpl) walk(path []string, value interface{}) (result map[string]interface{}) {
 	result = map[string]interface{}{
 		strings.Join(path, "."): value,
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValueMapperNumbers(t *testing.T) {
+	mapper := KeyValueMapperImpl{}
+	result := mapper.Get([]byte(`{"counter": 9007199254740993, "max": 9223372036854775807, "decimal": 0.1000000000000000055511151231257827, "exp": 1.5e300, "list": [18446744073709551615]}`))
+	expected := map[string]interface{}{
+		"counter": json.Number("9007199254740993"),
+		"max":     json.Number("9223372036854775807"),
+		"decimal": json.Number("0.1000000000000000055511151231257827"),
+		"exp":     json.Number("1.5e300"),
+		"list.0":  json.Number("18446744073709551615"),
+	}
+	for path, value := range expected {
+		if !reflect.DeepEqual(result[path], value) {
+			t.Error(path, result[path], value)
+		}
+	}
+
+	encoded, err := json.Marshal(result["counter"])
+	if err != nil {
+		t.Error(err)
+	}
+	if string(encoded) != "9007199254740993" {
+		t.Error(string(encoded))
+	}
+}
+
+func TestKeyValueMapperNonJson(t *testing.T) {
+	mapper := KeyValueMapperImpl{}
+	for message, expected := range map[string]interface{}{
+		``:       "",
+		`bar`:    "bar",
+		`42 foo`: "42 foo",
+		`{} {}`:  "{} {}",
+		` 42 `:   json.Number("42"),
+		`null`:   nil,
+	} {
+		result := mapper.Get([]byte(message))
+		if !reflect.DeepEqual(result[""], expected) {
+			t.Error(message, result[""], expected)
+		}
+	}
+}
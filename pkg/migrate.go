@@ -0,0 +1,121 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"log"
+	"sync"
+)
+
+type MigrationResult struct {
+	Source   int //number of last values in the source backend
+	Copied   int
+	Verified int //number of source values found unchanged in the target backend
+	Expired  int //number of source values not copied, because the target backend would expire them right away (see Expirer)
+}
+
+// Migrate copies all last values, including their value, receive and last seen times, from the backend named from to the backend named to.
+// both backends use their locations from config. with dryRun, only the source is read and the target is not opened.
+// after copying, every copied source value is compared with the target; a mismatch is returned as error.
+// the history is not migrated.
+func Migrate(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, from string, to string, dryRun bool, progressInterval int) (result MigrationResult, err error) {
+	if from == to {
+		return result, errors.New("source and target backend must differ")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	source, err := storage.New(ctx, wg, from, config)
+	if err != nil {
+		return result, errors.New("unable to open source backend " + from + ":" + err.Error())
+	}
+	if dryRun {
+		err = source.Scan("", func(record model.Record) error {
+			result.Source++
+			logProgress("found", result.Source, progressInterval)
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		log.Println("dry-run: would migrate", result.Source, "values from", from, "to", to)
+		return result, nil
+	}
+	target, err := storage.New(ctx, wg, to, config)
+	if err != nil {
+		return result, errors.New("unable to open target backend " + to + ":" + err.Error())
+	}
+	err = source.Scan("", func(record model.Record) error {
+		result.Source++
+		if expired(target, record) {
+			result.Expired++
+			return nil
+		}
+		err := target.Set(record)
+		if err != nil {
+			return fmt.Errorf("unable to write %v %v: %w", record.DeviceKey, record.ServiceKey, err)
+		}
+		result.Copied++
+		logProgress("copied", result.Copied, progressInterval)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	log.Println("copied", result.Copied, "values from", from, "to", to, "; start verification")
+	sourceCount := 0
+	err = source.Scan("", func(record model.Record) error {
+		sourceCount++
+		if expired(target, record) {
+			return nil
+		}
+		copied, found, err := target.Get(record.DeviceKey, record.ServiceKey)
+		if err != nil {
+			return err
+		}
+		if !found || !bytes.Equal(copied.Value, record.Value) || !copied.Time.Equal(record.Time) || !copied.Received.Equal(record.Received) || !copied.LastSeen.Equal(record.LastSeen) {
+			log.Println("ERROR: migrated value differs", record.DeviceKey, record.ServiceKey)
+			return nil
+		}
+		result.Verified++
+		logProgress("verified", result.Verified, progressInterval)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if sourceCount != result.Source || result.Verified != result.Copied {
+		return result, fmt.Errorf("verification failed: %v source values, %v copied, %v verified", result.Source, result.Copied, result.Verified)
+	}
+	if result.Expired > 0 {
+		log.Println("WARNING: skipped", result.Expired, "values that", to, "would expire right away")
+	}
+	log.Println("verified", result.Verified, "of", result.Source, "values")
+	return result, nil
+}
+
+func logProgress(action string, count int, interval int) {
+	if interval > 0 && count%interval == 0 {
+		log.Println(action, count, "values")
+	}
+}
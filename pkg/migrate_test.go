@@ -0,0 +1,103 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	config := configuration.Config{
+		BoltLocation:     t.TempDir() + "/bolt.db",
+		BadgerLocation:   t.TempDir(),
+		BadgerGcInterval: "1h",
+	}
+	valueTime := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	receivedTime := time.Now().Truncate(time.Millisecond)
+
+	t.Run("init source", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		source, err := storage.New(ctx, wg, "bolt", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range [][2]string{{"d1", "s1"}, {"d1", "s2"}, {"d.2", "s.1"}} {
+			err = source.Set(model.Record{DeviceKey: key[0], ServiceKey: key[1], Value: []byte(key[0] + key[1]), Time: valueTime, Received: receivedTime})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("dry-run", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		result, err := Migrate(context.Background(), wg, config, "bolt", "badger", true, 1)
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Source != 3 || result.Copied != 0 {
+			t.Error(result)
+		}
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		result, err := Migrate(context.Background(), wg, config, "bolt", "badger", false, 1)
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Source != 3 || result.Copied != 3 || result.Verified != 3 {
+			t.Error(result)
+		}
+	})
+
+	t.Run("check target", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		target, err := storage.New(ctx, wg, "badger", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, found, err := target.Get("d.2", "s.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || string(record.Value) != "d.2s.1" || !record.Time.Equal(valueTime) || !record.Received.Equal(receivedTime) {
+			t.Error(found, record)
+		}
+	})
+
+	t.Run("same backend", func(t *testing.T) {
+		_, err := Migrate(context.Background(), nil, config, "bolt", "bolt", false, 0)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
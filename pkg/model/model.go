@@ -0,0 +1,151 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+type Record struct {
+	DeviceKey  string
+	ServiceKey string
+	Value      []byte
+	Time       time.Time //time of the value; taken from the payload if configured, else equal to Received
+	Received   time.Time //time the value was received, i.e. the time of the last change
+	LastSeen   time.Time //time of the last message for the key, including suppressed unchanged values; never before Received
+}
+
+// MaxFutureTime is the tolerated difference of value times after receive times (e.g. clock skew of devices)
+const MaxFutureTime = time.Minute
+
+// OrderTime is the time used to order the values of a key: the value time or, if it is more than MaxFutureTime
+// after the receive time, the receive time; so a single value with a wrong future time can not block later values
+func (this Record) OrderTime() time.Time {
+	if !this.Received.IsZero() && this.Time.After(this.Received.Add(MaxFutureTime)) {
+		return this.Received
+	}
+	return this.Time
+}
+
+type Device struct {
+	Id           string    `json:"id"`
+	LastUpdate   time.Time `json:"last_update"`
+	ServiceCount int       `json:"service_count"`
+}
+
+type Service struct {
+	Id         string    `json:"id"`
+	LastUpdate time.Time `json:"last_update"`
+}
+
+type Status string
+
+const (
+	StatusFound       Status = "found"
+	StatusKeyMissing  Status = "key-missing"
+	StatusPathMissing Status = "path-missing"
+	StatusError       Status = "error"
+)
+
+type LastValue struct {
+	Value    interface{}
+	Time     *time.Time
+	Received *time.Time
+	LastSeen *time.Time
+	Status   Status
+}
+
+type HistoryValue struct {
+	Time  time.Time   `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Ignored  int `json:"ignored"` //values older than the stored value
+	Expired  int `json:"expired"` //values the storage would expire right away (see pkg.Expirer); they are not stored
+}
+
+// StorageStats describe the size and state of the storage backend; the fields of other backends are nil
+type StorageStats struct {
+	Backend        string            `json:"backend"`
+	Values         int               `json:"values"`
+	HistoryEntries int               `json:"history_entries"`
+	DiskSize       int64             `json:"disk_size"` //bytes
+	Compression    *CompressionStats `json:"compression,omitempty"`
+	Badger         *BadgerStats      `json:"badger,omitempty"`
+	Bolt           *BoltStats        `json:"bolt,omitempty"`
+}
+
+// CompressionStats describe the payloads encoded since start
+type CompressionStats struct {
+	Compression       string  `json:"compression"`
+	Records           int64   `json:"records"`
+	CompressedRecords int64   `json:"compressed_records"`
+	PayloadBytes      int64   `json:"payload_bytes"`
+	StoredBytes       int64   `json:"stored_bytes"`
+	SavedBytes        int64   `json:"saved_bytes"`
+	SavedRatio        float64 `json:"saved_ratio"`
+}
+
+type BadgerStats struct {
+	LsmSize  int64              `json:"lsm_size"`  //bytes
+	VlogSize int64              `json:"vlog_size"` //bytes
+	LastGc   *MaintenanceResult `json:"last_gc,omitempty"`
+}
+
+type BoltStats struct {
+	PageSize       int                `json:"page_size"`
+	FreePages      int                `json:"free_pages"`
+	PendingPages   int                `json:"pending_pages"`  //pages freed by transactions that are still read
+	FreeAlloc      int                `json:"free_alloc"`     //bytes allocated in free pages
+	FreelistInuse  int                `json:"freelist_inuse"` //bytes used by the freelist
+	OpenReadTx     int                `json:"open_read_tx"`
+	LastCompaction *MaintenanceResult `json:"last_compaction,omitempty"`
+}
+
+// MaintenanceResult describes a run of the badger value log gc or the bolt compaction
+type MaintenanceResult struct {
+	Operation  string    `json:"operation"` //"gc" or "compaction"
+	Start      time.Time `json:"start"`
+	Duration   string    `json:"duration"`
+	Rewritten  int       `json:"rewritten,omitempty"` //value log files rewritten by the gc
+	SizeBefore int64     `json:"size_before"`         //bytes
+	SizeAfter  int64     `json:"size_after"`          //bytes
+	Error      string    `json:"error,omitempty"`
+}
+
+// ErrNotSupported is returned for operations the storage backend does not implement
+var ErrNotSupported = errors.New("not supported by the storage backend")
+
+// Recovery describes the replacement of a corrupt database on start
+type Recovery struct {
+	Time           time.Time `json:"time"`
+	Backend        string    `json:"backend"`
+	Error          string    `json:"error"`       //reason the database could not be opened
+	MovedFiles     []string  `json:"moved_files"` //new locations of the corrupt database files
+	RestoreSource  string    `json:"restore_source,omitempty"`
+	Restored       int       `json:"restored"`        //values imported from RestoreSource
+	RestoreExpired int       `json:"restore_expired"` //values of RestoreSource that were skipped, because they are expired
+	RestoreError   string    `json:"restore_error,omitempty"`
+}
+
+type Health struct {
+	Status   string    `json:"status"` //"ok" or "recovered"
+	Recovery *Recovery `json:"recovery,omitempty"`
+}
@@ -57,7 +57,7 @@ func (this *Mqtt) init(ctx context.Context) error {
 		AddBroker(this.brokerUrl).
 		SetResumeSubs(true).
 		SetWriteTimeout(10 * time.Second).
-		SetOrderMatters(false).
+		SetOrderMatters(true). //handlers are called in order of arrival; blocking handlers apply back-pressure (see pkg.KeyDispatcher)
 		SetConnectionLostHandler(func(_ paho.Client, err error) {
 			log.Println("connection to mqtt broker lost")
 		}).
@@ -31,15 +31,35 @@ func Start(baseCtx context.Context, wg *sync.WaitGroup, config configuration.Con
 			cancel()
 		}
 	}()
-	db, err := storage.NewWithConfig(ctx, wg, config)
+	//the storage is closed after the ingest workers stored all received messages
+	storageCtx, stopStorage := context.WithCancel(context.WithoutCancel(ctx))
+	ingestWg := &sync.WaitGroup{}
+	go func() {
+		<-ctx.Done()
+		ingestWg.Wait()
+		stopStorage()
+	}()
+	db, recovery, err := storage.NewWithRecovery(storageCtx, wg, config)
 	if err != nil {
 		return err
 	}
-	err = api.Start(ctx, wg, config, NewQuery(KeyValueMapperImpl{Debug: config.Debug}, db))
+	if recovery != nil && config.StorageRecoveryRestore != "" {
+		restore(db, config.StorageRecoveryRestore, recovery)
+	}
+	mapper := KeyValueMapperImpl{Debug: config.Debug}
+	var store Storage = db
+	query := NewQuery(mapper, store)
+	if config.IndexOnIngest {
+		index := NewIndex(mapper, store, int(config.IndexSize))
+		store = index
+		query = NewIndexedQuery(index)
+	}
+	query.recovery = recovery
+	err = api.Start(ctx, wg, config, query)
 	if err != nil {
 		return err
 	}
-	err = Worker(ctx, config, db)
+	err = Worker(ctx, ingestWg, config, store)
 	if err != nil {
 		return err
 	}
@@ -57,6 +57,17 @@ func TestLastTestValueApiWithBolt(t *testing.T) {
 	testLastValueApi(config, t)
 }
 
+func TestLastTestValueApiWithMemory(t *testing.T) {
+	config, err := configuration.Load("../config.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config.StorageSelection = "memory"
+	config.MemorySnapshotLocation = t.TempDir() + "/snapshot.json"
+	testLastValueApi(config, t)
+}
+
 func TestLastTestValueApiWithAuto(t *testing.T) {
 	config, err := configuration.Load("../config.json")
 	if err != nil {
@@ -69,6 +80,18 @@ func TestLastTestValueApiWithAuto(t *testing.T) {
 	testLastValueApi(config, t)
 }
 
+func TestLastTestValueApiWithIndex(t *testing.T) {
+	config, err := configuration.Load("../config.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config.StorageSelection = "bolt"
+	config.BoltLocation = t.TempDir() + "/last_value.db"
+	config.IndexOnIngest = true
+	testLastValueApi(config, t)
+}
+
 func testLastValueApi(config configuration.Config, t *testing.T) {
 	wg := &sync.WaitGroup{}
 	defer wg.Wait()
@@ -81,6 +104,10 @@ func testLastValueApi(config configuration.Config, t *testing.T) {
 		return
 	}
 	config.Debug = true
+	config.TopicTemplates = append(config.TopicTemplates,
+		configuration.TopicTemplate{Template: "custom/{device}/{service...}", Payload: PayloadRaw},
+		configuration.TopicTemplate{Template: "timed/{device}/{service}", Payload: PayloadRaw, TimePath: "t", TimeFormat: TimeFormatUnix},
+	)
 	config, err = mqttEnv(config, ctx, wg)
 	if err != nil {
 		t.Error(err)
@@ -148,6 +175,16 @@ func testLastValueApi(config configuration.Config, t *testing.T) {
 			t.Error(err)
 			return
 		}
+		err = client.Publish("event/d1/s10", 2, false, []byte(`{"counter": 9007199254740993, "decimal": 0.1000000000000000055511151231257827}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = client.Publish("custom/d1/s11/sub", 2, false, []byte(`{"foo": "bar"}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
 		err = client.Publish("event/d1/replace", 2, false, []byte(`42`))
 		if err != nil {
 			t.Error(err)
@@ -179,9 +216,35 @@ func testLastValueApi(config configuration.Config, t *testing.T) {
 		}
 	})
 
+	t.Run("send concurrent values to mqtt", func(t *testing.T) {
+		publishers := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			publishers.Add(1)
+			go func(i int) {
+				defer publishers.Done()
+				client, err := mqtt.New(ctx, config.MqttBroker, "test-client-"+strconv.Itoa(i), config.MqttUser, config.MqttPw)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				//newest value is published first
+				for j := 9; j >= 0; j-- {
+					value := i*10 + j
+					err = client.Publish("timed/d2/concurrent", 2, false, []byte(`{"t": `+strconv.Itoa(1700000000+value)+`, "v": `+strconv.Itoa(value)+`}`))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(i)
+		}
+		publishers.Wait()
+	})
+
 	time.Sleep(1 * time.Second)
 
 	t.Run("query", func(t *testing.T) {
+		t.Run(queryTest(config, "d2", "concurrent", "v", float64(99), true))
 		t.Run(queryTest(config, "d1", "s0", "", "", true))
 		t.Run(queryTest(config, "unknown", "s1", "", nil, false))
 		t.Run(queryTest(config, "d1", "s1", "", map[string]interface{}{}, true))
@@ -206,6 +269,81 @@ func testLastValueApi(config configuration.Config, t *testing.T) {
 		t.Run(queryTest(config, "d1", "cmd2", "", "42", true))
 		t.Run(queryTest(config, "d1", "cmd3", "", "foo", true))
 		t.Run(queryTest(config, "d1", "cmd4", "", "bar", true))
+		t.Run(queryTest(config, "d1", "s11/sub", "foo", "bar", true))
+	})
+
+	t.Run("numbers", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		err := json.NewEncoder(buff).Encode([]map[string]string{
+			{"DeviceId": "d1", "ServiceId": "s10", "ColumnName": "counter"},
+			{"DeviceId": "d1", "ServiceId": "s10", "ColumnName": "decimal"},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := http.Post("http://localhost:"+config.HttpPort+"/last-values", "application/json", buff)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		result := []struct {
+			Value json.Number `json:"value"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(result) != 2 || result[0].Value != "9007199254740993" || result[1].Value != "0.1000000000000000055511151231257827" {
+			t.Error(result)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		t.Run(statusTest(config, "d1", "s6", "", false, "found", 0))
+		t.Run(statusTest(config, "d1", "s7", "bar", false, "path-missing", 0))
+		t.Run(statusTest(config, "unknown", "s1", "", false, "key-missing", 0))
+		t.Run(statusTest(config, "d1", "s6", "", true, "found", 0))
+		t.Run(statusTest(config, "d1", "s7", "bar", true, "path-missing", 404))
+		t.Run(statusTest(config, "unknown", "s1", "", true, "key-missing", 404))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		type Entry struct {
+			Id         string    `json:"id"`
+			LastUpdate time.Time `json:"last_update"`
+		}
+		list := func(path string) (result []Entry, total string) {
+			resp, err := http.Get("http://localhost:" + config.HttpPort + path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Error(resp.StatusCode)
+				return
+			}
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			if err != nil {
+				t.Error(err)
+			}
+			return result, resp.Header.Get("X-Total-Count")
+		}
+		devices, total := list("/devices")
+		if len(devices) != 2 || devices[0].Id != "d1" || devices[0].LastUpdate.IsZero() || total != "2" {
+			t.Error(devices, total)
+		}
+		services, total := list("/devices/d1/services?limit=2&offset=1")
+		if len(services) != 2 || services[0].Id != "cmd2" || services[1].Id != "cmd3" || total != "16" {
+			t.Error(services, total)
+		}
+		services, total = list("/devices/unknown/services")
+		if len(services) != 0 || total != "0" {
+			t.Error(services, total)
+		}
 	})
 }
 
@@ -265,6 +403,44 @@ func queryTest(config configuration.Config, deviceKey string, serviceKey string,
 	}
 }
 
+func statusTest(config configuration.Config, deviceKey string, serviceKey string, path string, strict bool, expectedStatus string, expectedCode int) (testName string, f func(t *testing.T)) {
+	return strings.Join([]string{deviceKey, serviceKey, path, strconv.FormatBool(strict)}, "."), func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		err := json.NewEncoder(buff).Encode([]map[string]string{{
+			"DeviceId":   deviceKey,
+			"ServiceId":  serviceKey,
+			"ColumnName": path,
+		}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := http.Post("http://localhost:"+config.HttpPort+"/last-values?strict="+strconv.FormatBool(strict), "application/json", buff)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		result := []struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+			Code   int    `json:"code"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(result) != 1 {
+			t.Error(result)
+			return
+		}
+		if result[0].Status != expectedStatus || result[0].Code != expectedCode || (expectedCode != 0) != (result[0].Error != "") {
+			t.Error(result[0], expectedStatus, expectedCode)
+		}
+	}
+}
+
 func mqttEnv(config configuration.Config, ctx context.Context, wg *sync.WaitGroup) (configuration.Config, error) {
 	mqttPort, _, err := Mqtt(ctx, wg)
 	if err != nil {
@@ -292,7 +468,8 @@ func Mqtt(ctx context.Context, wg *sync.WaitGroup) (hostPort string, ipAddress s
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
-		timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
+		timeout, timeoutCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer timeoutCancel()
 		log.Println("DEBUG: remove container mqtt", c.Terminate(timeout))
 	}()
 
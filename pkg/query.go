@@ -16,7 +16,7 @@
 
 package pkg
 
-import "time"
+import "github.com/SENERGY-Platform/mgw-last-value/pkg/model"
 
 type KeyValueMapper interface {
 	Get(message []byte) map[string]interface{}
@@ -25,6 +25,9 @@ type KeyValueMapper interface {
 type Query struct {
 	mapper KeyValueMapper
 	db     Storage
+	index  *Index
+
+	recovery *model.Recovery //set if the database was replaced on start
 }
 
 func NewQuery(mapper KeyValueMapper, db Storage) *Query {
@@ -34,14 +37,43 @@ func NewQuery(mapper KeyValueMapper, db Storage) *Query {
 	}
 }
 
-func (this *Query) Get(deviceKey, serviceKey, path string) (value interface{}, time *time.Time, err error) {
-	key := deviceKey + "." + serviceKey
-	var tempVal []byte
-	tempVal, time, err = this.db.Get(key)
+// NewIndexedQuery reads the flattened paths of last values from the index instead of mapping them on every read
+func NewIndexedQuery(index *Index) *Query {
+	return &Query{
+		mapper: index.mapper,
+		db:     index,
+		index:  index,
+	}
+}
+
+func (this *Query) Get(deviceKey, serviceKey, path string) (result model.LastValue, err error) {
+	record, found, err := this.db.Get(deviceKey, serviceKey)
 	if err != nil {
-		return value, time, err
+		return result, err
+	}
+	if !found {
+		if this.index != nil {
+			this.index.forget(deviceKey, serviceKey)
+		}
+		result.Status = model.StatusKeyMissing
+		return result, nil
+	}
+	result.Time = &record.Time
+	result.Received = &record.Received
+	result.LastSeen = &record.LastSeen
+	value, ok := this.paths(record)[path]
+	if !ok {
+		result.Status = model.StatusPathMissing
+		return result, nil
+	}
+	result.Value = value
+	result.Status = model.StatusFound
+	return result, nil
+}
+
+func (this *Query) paths(record model.Record) map[string]interface{} {
+	if this.index != nil {
+		return this.index.Paths(record)
 	}
-	mapped := this.mapper.Get(tempVal)
-	value = mapped[path]
-	return value, time, nil
+	return this.mapper.Get(record.Value)
 }
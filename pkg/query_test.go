@@ -0,0 +1,168 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/bolt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type StorageMock struct {
+	Storage
+	values map[string]model.Record
+}
+
+func (this *StorageMock) Set(record model.Record) error {
+	this.values[record.DeviceKey+"/"+record.ServiceKey] = record
+	return nil
+}
+
+func (this *StorageMock) Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error) {
+	record, found = this.values[deviceKey+"/"+serviceKey]
+	return record, found, nil
+}
+
+func TestIndexedQuery(t *testing.T) {
+	db := &StorageMock{values: map[string]model.Record{}}
+	index := NewIndex(KeyValueMapperImpl{}, db, 0)
+	query := NewIndexedQuery(index)
+
+	check := func(path string, expected interface{}) {
+		t.Helper()
+		result, err := query.Get("d", "s", path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if result.Status != model.StatusFound || !reflect.DeepEqual(result.Value, expected) {
+			t.Error(path, result, expected)
+		}
+	}
+
+	err := index.Set(testRecord("d", "s", []byte(`{"foo":{"bar":42}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("foo.bar", json.Number("42"))
+
+	//written without index --> paths are recomputed
+	err = db.Set(testRecord("d", "s", []byte(`{"foo":{"bar":13}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("foo.bar", json.Number("13"))
+	check("foo", map[string]interface{}{"bar": json.Number("13")})
+
+	//removed without index --> entry is dropped
+	delete(db.values, "d/s")
+	result, err := query.Get("d", "s", "foo.bar")
+	if err != nil || result.Status != model.StatusKeyMissing {
+		t.Error(result, err)
+	}
+	if len(index.entries) != 0 {
+		t.Error(index.entries)
+	}
+}
+
+func TestIndexEviction(t *testing.T) {
+	index := NewIndex(KeyValueMapperImpl{}, &StorageMock{values: map[string]model.Record{}}, 2)
+	for _, service := range []string{"s1", "s2", "s3"} {
+		err := index.Set(testRecord("d", service, []byte(`{"foo":42}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if service == "s2" {
+			//s1 becomes the most recently used entry
+			record, _, _ := index.Get("d", "s1")
+			index.Paths(record)
+		}
+	}
+	if index.lru.Len() != 2 || len(index.entries) != 2 {
+		t.Error(index.lru.Len(), len(index.entries))
+	}
+	for service, expected := range map[string]bool{"s1": true, "s2": false, "s3": true} {
+		if _, ok := index.entries[indexKey{deviceKey: "d", serviceKey: service}]; ok != expected {
+			t.Error(service, ok, expected)
+		}
+	}
+}
+
+// BenchmarkQueryGet reads from bolt, so the costs of reading and decoding the stored value are included
+func BenchmarkQueryGet(b *testing.B) {
+	parts := []string{}
+	for i := 0; i < 200; i++ {
+		parts = append(parts, fmt.Sprintf(`"field_%v":{"value":%v,"unit":"kWh","history":[1,2,3,4,5]}`, i, i))
+	}
+	payload := []byte("{" + strings.Join(parts, ",") + "}")
+
+	newStore := func(b *testing.B) Storage {
+		wg := &sync.WaitGroup{}
+		ctx, cancel := context.WithCancel(context.Background())
+		b.Cleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		store, err := bolt.New(ctx, wg, b.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 0, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		return store
+	}
+
+	b.Run("raw", func(b *testing.B) {
+		db := newStore(b)
+		query := NewQuery(KeyValueMapperImpl{}, db)
+		benchmarkQueryGet(b, db, query, payload)
+	})
+
+	b.Run("indexed", func(b *testing.B) {
+		index := NewIndex(KeyValueMapperImpl{}, newStore(b), 0)
+		query := NewIndexedQuery(index)
+		benchmarkQueryGet(b, index, query, payload)
+	})
+}
+
+func benchmarkQueryGet(b *testing.B, db Storage, query *Query, payload []byte) {
+	err := db.Set(testRecord("d", "s", payload))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := query.Get("d", "s", "field_"+strconv.Itoa(i%200)+".value")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Status != model.StatusFound {
+			b.Fatal(result)
+		}
+	}
+}
+
+func testRecord(deviceKey string, serviceKey string, value []byte) model.Record {
+	now := time.Now()
+	return model.Record{DeviceKey: deviceKey, ServiceKey: serviceKey, Value: value, Time: now, Received: now}
+}
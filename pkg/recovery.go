@@ -0,0 +1,92 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// restore imports the latest export found at source (see latestExport) into the fresh database of a recovery.
+// failures are recorded in recovery, the service starts with the values restored so far.
+func restore(store Storage, source string, recovery *model.Recovery) {
+	location, err := latestExport(source)
+	if err != nil {
+		log.Println("ERROR: unable to restore values after recovery:", err)
+		recovery.RestoreError = err.Error()
+		return
+	}
+	recovery.RestoreSource = location
+	file, err := os.Open(location)
+	if err != nil {
+		log.Println("ERROR: unable to restore values after recovery:", err)
+		recovery.RestoreError = err.Error()
+		return
+	}
+	defer file.Close()
+	result, err := Import(store, file)
+	recovery.Restored = result.Imported
+	recovery.RestoreExpired = result.Expired
+	if err != nil {
+		log.Println("ERROR: restore after recovery stopped after", result.Imported, "values:", err)
+		recovery.RestoreError = err.Error()
+		return
+	}
+	log.Println("restored", result.Imported, "values from", location, "; skipped", result.Expired, "expired values")
+}
+
+// latestExport returns location, or the most recently modified file in location if it is a directory
+func latestExport(location string) (string, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return location, nil
+	}
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		return "", err
+	}
+	result := ""
+	var latest os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			result, latest = filepath.Join(location, entry.Name()), info
+		}
+	}
+	if latest == nil {
+		return "", errors.New("no export file in " + location)
+	}
+	return result, nil
+}
+
+func (this *Query) Health() (result model.Health) {
+	result.Status = "ok"
+	if this.recovery != nil {
+		result.Status = "recovered"
+		result.Recovery = this.recovery
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/memory"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	err := os.WriteFile(filepath.Join(dir, "old.ndjson"), []byte(`{"device":"d","service":"old","payload":"1","time":"2024-01-01T00:00:00Z"}`+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(filepath.Join(dir, "old.ndjson"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "new.ndjson"), []byte(`{"device":"d","service":"new","payload":"2","time":"2024-01-01T00:00:00Z"}`+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := memory.New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery := &model.Recovery{Backend: "memory"}
+	restore(store, dir, recovery)
+	if recovery.RestoreSource != filepath.Join(dir, "new.ndjson") || recovery.Restored != 1 || recovery.RestoreError != "" {
+		t.Error(recovery)
+	}
+	_, found, _ := store.Get("d", "new")
+	if !found {
+		t.Error("missing restored value")
+	}
+
+	query := NewQuery(KeyValueMapperImpl{}, store)
+	if health := query.Health(); health.Status != "ok" {
+		t.Error(health)
+	}
+	query.recovery = recovery
+	if health := query.Health(); health.Status != "recovered" || health.Recovery != recovery {
+		t.Error(health)
+	}
+
+	recovery = &model.Recovery{Backend: "memory"}
+	restore(store, t.TempDir(), recovery)
+	if recovery.RestoreError == "" {
+		t.Error("expected error for empty directory")
+	}
+}
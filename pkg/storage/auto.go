@@ -0,0 +1,149 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// minimal resources for badger with the default options (memtables and block cache alone take about 600MB)
+const autoBadgerMinMemory = 1 << 30
+const autoBadgerMinDisk = 1 << 30
+
+// minimal resources for badger with badger_profile "low_memory"
+const autoLowMemoryBadgerMinMemory = 256 << 20
+const autoLowMemoryBadgerMinDisk = 128 << 20
+
+// capabilities of the host; memoryKnown and diskKnown are false if detection is not supported or failed
+type capabilities struct {
+	memory      uint64 //available memory in bytes, limited by the cgroup of the container
+	memoryKnown bool
+	disk        uint64 //free disk space in bytes at the badger location
+	diskKnown   bool
+}
+
+func detectCapabilities(config configuration.Config) (result capabilities) {
+	var err error
+	result.memory, err = availableMemory()
+	if err != nil {
+		log.Println("WARNING: unable to detect available memory:", err)
+	} else {
+		result.memoryKnown = true
+	}
+	result.disk, err = freeDisk(existingParent(config.BadgerLocation))
+	if err != nil {
+		log.Println("WARNING: unable to detect free disk space:", err)
+	} else {
+		result.diskKnown = true
+	}
+	return result
+}
+
+// autoSelection selects bolt or badger for storage_selection "auto" and explains the decision in reason.
+// existing databases are always kept, so that updates never switch to an empty backend;
+// new installations use badger if the host has enough memory and disk space for it.
+func autoSelection(config configuration.Config, detect func(config configuration.Config) capabilities) (backend string, reason string) {
+	//invalid sections are reported by the factory
+	config, _ = ApplySection(config, "bolt", config.StorageConfig["bolt"])
+	config, _ = ApplySection(config, "badger", config.StorageConfig["badger"])
+	boltTime, boltExists := boltDatabase(config.BoltLocation)
+	badgerTime, badgerExists := badgerDatabase(config)
+	switch {
+	case boltExists && badgerExists:
+		if boltTime.After(badgerTime) {
+			return "bolt", fmt.Sprintf("bolt and badger databases exist; %v was modified more recently than %v", config.BoltLocation, config.BadgerLocation)
+		}
+		return "badger", fmt.Sprintf("bolt and badger databases exist; %v was modified more recently than %v", config.BadgerLocation, config.BoltLocation)
+	case boltExists:
+		return "bolt", "existing bolt database at " + config.BoltLocation
+	case badgerExists:
+		return "badger", "existing badger database at " + config.BadgerLocation
+	}
+
+	if strconv.IntSize == 32 {
+		return "bolt", "no existing database; 32-bit address space is too small for the badger value log"
+	}
+	minMemory, minDisk := uint64(autoBadgerMinMemory), uint64(autoBadgerMinDisk)
+	if config.BadgerProfile == "low_memory" {
+		minMemory, minDisk = autoLowMemoryBadgerMinMemory, autoLowMemoryBadgerMinDisk
+	}
+	found := detect(config)
+	if found.memoryKnown && found.memory < minMemory {
+		return "bolt", fmt.Sprintf("no existing database; %vMB available memory is less than the %vMB needed by badger", found.memory>>20, minMemory>>20)
+	}
+	if found.diskKnown && found.disk < minDisk && !config.BadgerInMemory {
+		return "bolt", fmt.Sprintf("no existing database; %vMB free disk space is less than the %vMB needed by badger", found.disk>>20, minDisk>>20)
+	}
+	if !found.memoryKnown || !found.diskKnown {
+		return "badger", "no existing database; resources could not be detected"
+	}
+	return "badger", fmt.Sprintf("no existing database; %vMB available memory and %vMB free disk space are enough for badger", found.memory>>20, found.disk>>20)
+}
+
+// boltDatabase returns the modification time of the bolt file at location, if it exists and is not empty
+func boltDatabase(location string) (modified time.Time, exists bool) {
+	info, err := os.Stat(location)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return modified, false
+	}
+	return info.ModTime(), true
+}
+
+// badgerDatabase returns the latest modification time of the badger files, if a badger database exists at config.BadgerLocation
+func badgerDatabase(config configuration.Config) (modified time.Time, exists bool) {
+	if config.BadgerInMemory || config.BadgerLocation == "" {
+		return modified, false
+	}
+	if _, err := os.Stat(filepath.Join(config.BadgerLocation, "MANIFEST")); err != nil {
+		return modified, false
+	}
+	entries, err := os.ReadDir(config.BadgerLocation)
+	if err != nil {
+		return modified, false
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, true
+}
+
+// existingParent returns location or its closest existing parent directory
+func existingParent(location string) string {
+	location, err := filepath.Abs(location)
+	if err != nil {
+		return "."
+	}
+	for {
+		if _, err = os.Stat(location); err == nil {
+			return location
+		}
+		parent := filepath.Dir(location)
+		if parent == location {
+			return location
+		}
+		location = parent
+	}
+}
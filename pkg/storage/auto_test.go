@@ -0,0 +1,130 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAutoSelection(t *testing.T) {
+	dir := t.TempDir()
+	config := configuration.Config{
+		StorageSelection: "auto",
+		BoltLocation:     filepath.Join(dir, "last_value.db"),
+		BadgerLocation:   filepath.Join(dir, "db"),
+	}
+	detected := func(memory uint64, disk uint64) func(config configuration.Config) capabilities {
+		return func(config configuration.Config) capabilities {
+			return capabilities{memory: memory, memoryKnown: true, disk: disk, diskKnown: true}
+		}
+	}
+	unknown := func(config configuration.Config) capabilities {
+		return capabilities{}
+	}
+	check := func(t *testing.T, config configuration.Config, detect func(config configuration.Config) capabilities, expected string) {
+		t.Helper()
+		backend, reason := autoSelection(config, detect)
+		if backend != expected {
+			t.Error(backend, reason)
+		}
+	}
+
+	t.Run("new installation", func(t *testing.T) {
+		check(t, config, detected(4<<30, 10<<30), "badger")
+		check(t, config, detected(512<<20, 10<<30), "bolt")
+		check(t, config, detected(4<<30, 512<<20), "bolt")
+		check(t, config, unknown, "badger")
+
+		lowMemory := config
+		lowMemory.BadgerProfile = "low_memory"
+		check(t, lowMemory, detected(512<<20, 512<<20), "badger")
+		check(t, lowMemory, detected(128<<20, 512<<20), "bolt")
+
+		inMemory := config
+		inMemory.BadgerInMemory = true
+		check(t, inMemory, detected(4<<30, 0), "badger")
+
+		lowMemorySection := config
+		lowMemorySection.StorageConfig = map[string]json.RawMessage{"badger": json.RawMessage(`{"profile":"low_memory"}`)}
+		check(t, lowMemorySection, detected(512<<20, 512<<20), "badger")
+	})
+
+	t.Run("existing bolt", func(t *testing.T) {
+		err := os.WriteFile(config.BoltLocation, []byte("bolt"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, config, detected(4<<30, 10<<30), "bolt")
+
+		moved := config
+		moved.BoltLocation = filepath.Join(dir, "unknown.db")
+		check(t, moved, detected(4<<30, 10<<30), "badger")
+		moved.StorageConfig = map[string]json.RawMessage{"bolt": json.RawMessage(`{"location":` + strconv.Quote(config.BoltLocation) + `}`)}
+		check(t, moved, detected(4<<30, 10<<30), "bolt")
+	})
+
+	t.Run("existing badger", func(t *testing.T) {
+		err := os.Remove(config.BoltLocation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.MkdirAll(config.BadgerLocation, 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(config.BadgerLocation, "MANIFEST"), []byte("badger"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, config, detected(128<<20, 10<<20), "badger")
+	})
+
+	t.Run("both existing", func(t *testing.T) {
+		err := os.WriteFile(config.BoltLocation, []byte("bolt"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour)
+		err = os.Chtimes(filepath.Join(config.BadgerLocation, "MANIFEST"), old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, config, unknown, "bolt")
+		err = os.Chtimes(config.BoltLocation, old.Add(-time.Hour), old.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, config, unknown, "badger")
+	})
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	found := detectCapabilities(configuration.Config{BadgerLocation: filepath.Join(t.TempDir(), "missing", "db")})
+	if found.memoryKnown && found.memory == 0 {
+		t.Error(found)
+	}
+	if found.diskKnown && found.disk == 0 {
+		t.Error(found)
+	}
+	t.Log(found)
+}
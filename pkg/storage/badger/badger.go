@@ -18,9 +18,11 @@ package badger
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
 	"github.com/dgraph-io/badger/v3"
 	"log"
 	"sync"
@@ -28,21 +30,48 @@ import (
 )
 
 type BadgerStore struct {
-	db  *badger.DB
-	ttl time.Duration
+	db            *badger.DB
+	ttl           time.Duration
+	historyLength int
+	historyMaxAge time.Duration
+	historySeq    *badger.Sequence
+	codec         *codec.Codec
+	location      string //empty in memory
+
+	gcMux  sync.Mutex //guards lastGc
+	lastGc *model.MaintenanceResult
+	gcRun  sync.Mutex //serializes GC calls; badger rejects concurrent runs with badger.ErrRejected
 }
 
 func NewWithConfig(ctx context.Context, wg *sync.WaitGroup, config configuration.Config) (result *BadgerStore, err error) {
-	return New(ctx, wg, config.BadgerLocation, config.BadgerGcInterval, config.BadgerTtl)
+	encryptionKey, err := codec.LoadKey(config.EncryptionKey, config.EncryptionKeyFile)
+	if err != nil {
+		return result, err
+	}
+	return New(ctx, wg, config.BadgerLocation, TuningFromConfig(config), config.StorageVerify, config.BadgerGcInterval, config.Ttl, config.Compression, config.CompressionThreshold, encryptionKey, config.HistoryLength, config.HistoryMaxAge)
 }
 
-func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr string, ttlDurationString string) (result *BadgerStore, err error) {
+// New opens badger at location with the options of tuning and, if verify is set, checks the checksums of all tables; payloads larger than compressionThreshold bytes are compressed with compression ("", "gzip" or "zstd").
+// with encryptionKey, badger encrypts all data files natively; existing databases have to be converted with RotateKey.
+func New(ctx context.Context, wg *sync.WaitGroup, location string, tuning Tuning, verify bool, intervalStr string, ttlDurationString string, compression string, compressionThreshold int64, encryptionKey []byte, historyLength int64, historyMaxAgeStr string) (result *BadgerStore, err error) {
 	log.Println("start badger")
+	recordCodec, err := codec.NewCodec(compression, compressionThreshold, nil)
+	if err != nil {
+		return result, err
+	}
 	var ttl time.Duration
 	if ttlDurationString != "" {
 		ttl, err = time.ParseDuration(ttlDurationString)
 		if err != nil {
-			return result, errors.New("unable to parse badger ttl as duration:" + err.Error())
+			return result, errors.New("unable to parse ttl as duration:" + err.Error())
+		}
+	}
+
+	var historyMaxAge time.Duration
+	if historyMaxAgeStr != "" {
+		historyMaxAge, err = time.ParseDuration(historyMaxAgeStr)
+		if err != nil {
+			return result, errors.New("unable to parse history max age as duration:" + err.Error())
 		}
 	}
 
@@ -51,12 +80,48 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr s
 		return result, errors.New("unable to parse badger garbage collection interval as duration:" + err.Error())
 	}
 	result = &BadgerStore{
-		ttl: ttl,
+		ttl:           ttl,
+		historyLength: int(historyLength),
+		historyMaxAge: historyMaxAge,
+		codec:         recordCodec,
+	}
+	if !tuning.InMemory {
+		result.location = location
 	}
-	result.db, err = badger.Open(badger.DefaultOptions(location))
+	options, err := tuning.options(location, encryptionKey)
 	if err != nil {
 		return result, err
 	}
+	log.Println("badger options:", describeOptions(tuning.Profile, options))
+	result.db, err = badger.Open(options)
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return result, errors.New("badger encryption key does not match the database; use the rotate-key command to change the key: " + err.Error())
+	}
+	if err != nil {
+		return result, openError(err)
+	}
+
+	if verify {
+		err = result.verify()
+		if err != nil {
+			result.db.Close()
+			return result, storage.Corrupt(err)
+		}
+	}
+
+	err = result.migrateLegacyKeys()
+	if err != nil {
+		result.db.Close()
+		return result, err
+	}
+
+	result.historySeq, err = result.db.GetSequence(historySequenceKey, 100)
+	if err != nil {
+		result.db.Close()
+		return result, err
+	}
+
+	upgradeDone := result.startRecordUpgrade(ctx, wg)
 
 	//implement stop cleanup
 	if wg != nil {
@@ -67,13 +132,23 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr s
 			defer wg.Done()
 		}
 		<-ctx.Done()
+		<-upgradeDone
+		err = result.historySeq.Release()
+		if err != nil {
+			log.Println("WARNING: unable to release badger history sequence:", err)
+		}
 		err = result.db.Close()
 		if err != nil {
 			log.Println("WARNING: unable to close badger connection:", err)
 		}
 	}()
 
-	//implement garbage collection
+	result.codec.StartStatsLog(ctx, wg, "badger", time.Hour)
+
+	//implement garbage collection; in memory, there are no value log files to collect
+	if tuning.InMemory {
+		return result, nil
+	}
 	if wg != nil {
 		wg.Add(1)
 	}
@@ -88,9 +163,11 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr s
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				var err error
-				for err == nil {
-					err = result.db.RunValueLogGC(0.5)
+				gc, err := result.GC()
+				if err != nil {
+					log.Println("ERROR: badger value log gc failed after", gc.Duration+":", err)
+				} else if gc.Rewritten > 0 {
+					log.Println("badger value log gc rewrote", gc.Rewritten, "files in", gc.Duration+"; value log size", gc.SizeBefore, "->", gc.SizeAfter, "bytes")
 				}
 			}
 		}
@@ -99,51 +176,132 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr s
 	return result, nil
 }
 
-type ValueWithTime struct {
-	Value []byte    `json:"v"`
-	Time  time.Time `json:"t"`
+func (this *BadgerStore) encodeValue(record model.Record) ([]byte, error) {
+	return this.codec.Encode(record)
 }
 
-func (this *BadgerStore) Set(key string, value []byte) error {
-	jsonValue, err := json.Marshal(ValueWithTime{Value: value, Time: time.Now()})
+func decodeValue(deviceKey string, serviceKey string, item *badger.Item) (record model.Record, err error) {
+	err = item.Value(func(val []byte) error {
+		record, err = codec.Decode(deviceKey, serviceKey, val)
+		return err
+	})
 	if err != nil {
+		log.Println("ERROR: unable to read value from badger", deviceKey, serviceKey, err)
+	}
+	return record, err
+}
+
+func decodeTimes(deviceKey string, serviceKey string, item *badger.Item) (record model.Record, err error) {
+	err = item.Value(func(val []byte) error {
+		record, err = codec.DecodeTimes(deviceKey, serviceKey, val)
 		return err
+	})
+	if err != nil {
+		log.Println("ERROR: unable to read value from badger", deviceKey, serviceKey, err)
 	}
-	return this.db.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry([]byte(key), jsonValue)
+	return record, err
+}
+
+func (this *BadgerStore) Set(record model.Record) error {
+	_, err := this.set(record, false)
+	return err
+}
+
+// SetIfNewer replaces the last value only if the stored value is not newer than the record (see model.Record.OrderTime).
+// the record is added to the history in both cases.
+func (this *BadgerStore) SetIfNewer(record model.Record) (stored bool, err error) {
+	return this.set(record, true)
+}
+
+func (this *BadgerStore) set(record model.Record, onlyIfNewer bool) (stored bool, err error) {
+	encoded, err := this.encodeValue(record)
+	if err != nil {
+		return false, err
+	}
+	key := valueKey(record.DeviceKey, record.ServiceKey)
+	err = this.update(func(txn *badger.Txn) error {
+		stored = false
+		if onlyIfNewer {
+			//unreadable values are replaced
+			item, err := txn.Get(key)
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			if err == nil {
+				current, err := decodeValue(record.DeviceKey, record.ServiceKey, item)
+				if err == nil && current.OrderTime().After(record.OrderTime()) {
+					return this.appendHistory(txn, record.DeviceKey, record.ServiceKey, record.Time, encoded)
+				}
+			}
+		}
+		entry := badger.NewEntry(key, encoded)
 		if this.ttl != 0 {
 			entry.WithTTL(this.ttl)
 		}
-		return txn.SetEntry(entry)
+		err := txn.SetEntry(entry)
+		if err != nil {
+			return err
+		}
+		stored = true
+		return this.appendHistory(txn, record.DeviceKey, record.ServiceKey, record.Time, encoded)
 	})
+	return stored, err
 }
 
-func (this *BadgerStore) Get(key string) (value []byte, time *time.Time, err error) {
-	valWithTime := ValueWithTime{}
-	err = this.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
+// update retries transactions that conflict with concurrent writes to the same keys
+func (this *BadgerStore) update(f func(txn *badger.Txn) error) (err error) {
+	for i := 0; i < 10; i++ {
+		err = this.db.Update(f)
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// Touch sets the last seen time of the stored value, if seen is after it; missing values are ignored.
+// the history is not changed; like every write, Touch renews the ttl.
+func (this *BadgerStore) Touch(deviceKey string, serviceKey string, seen time.Time) error {
+	key := valueKey(deviceKey, serviceKey)
+	return this.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
 		if err == badger.ErrKeyNotFound {
-			//not found --> value = nil
-			value = []byte("null")
 			return nil
 		}
 		if err != nil {
-			log.Println("ERROR: unable to read value from badger", err)
 			return err
 		}
-		temp, err := item.ValueCopy(nil)
+		record, err := decodeValue(deviceKey, serviceKey, item)
+		if err != nil || !seen.After(record.LastSeen) {
+			return err
+		}
+		record.LastSeen = seen
+		encoded, err := this.encodeValue(record)
 		if err != nil {
-			log.Println("ERROR: unable to copy value from badger", err)
 			return err
 		}
-		err = json.Unmarshal(temp, &valWithTime)
+		entry := badger.NewEntry(key, encoded)
+		if this.ttl != 0 {
+			entry.WithTTL(this.ttl)
+		}
+		return txn.SetEntry(entry)
+	})
+}
+
+// Get returns found == false if no value is stored for the device and service
+func (this *BadgerStore) Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error) {
+	err = this.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(valueKey(deviceKey, serviceKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
 		if err != nil {
-			log.Println("ERROR: unable to unmarshal value from badger", err)
+			log.Println("ERROR: unable to read value from badger", err)
 			return err
 		}
-		value = valWithTime.Value
-		time = &valWithTime.Time
-		return nil
+		record, err = decodeValue(deviceKey, serviceKey, item)
+		found = err == nil
+		return err
 	})
-	return value, time, err
+	return record, found, err
 }
@@ -0,0 +1,772 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
+	"github.com/dgraph-io/badger/v3"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompositeKeys(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testRecord("a.b", "c", []byte("1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testRecord("a", "b.c", []byte("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, store, "a.b", "c", "1")
+	checkValue(t, store, "a", "b.c", "2")
+	checkMissing(t, store, "a", "b")
+}
+
+func TestLegacyMigration(t *testing.T) {
+	location := t.TempDir()
+	db, err := badger.Open(badger.DefaultOptions(location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		for key, value := range map[string]string{"d1.s1": "1", "d.2.s2": "2", "invalid": "3"} {
+			temp, err := json.Marshal(codec.ValueWithTime{Value: []byte(value), Time: time.Now()})
+			if err != nil {
+				return err
+			}
+			err = txn.SetEntry(badger.NewEntry([]byte(key), temp).WithTTL(time.Hour))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, location, Tuning{}, false, "3h", "", "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, store, "d1", "s1", "1")
+	checkValue(t, store, "d.2", "s2", "2")
+	err = store.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("d1.s1"))
+		if err != badger.ErrKeyNotFound {
+			t.Error("legacy key not removed", err)
+		}
+		item, err := txn.Get(valueKey("d1", "s1"))
+		if err != nil {
+			return err
+		}
+		if item.ExpiresAt() == 0 {
+			t.Error("ttl not migrated")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	//legacy json records are upgraded in the background
+	for i := 0; ; i++ {
+		upgraded := false
+		err = store.db.View(func(txn *badger.Txn) error {
+			if _, err := txn.Get(recordFormatKey); err != nil {
+				return nil
+			}
+			item, err := txn.Get(valueKey("d1", "s1"))
+			if err != nil {
+				return err
+			}
+			if item.ExpiresAt() == 0 {
+				t.Error("ttl not kept by upgrade")
+			}
+			return item.Value(func(val []byte) error {
+				upgraded = !codec.IsLegacy(val)
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upgraded {
+			break
+		}
+		if i > 100 {
+			t.Fatal("record not upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkValue(t, store, "d1", "s1", "1")
+}
+
+func TestScan(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"a", "s1"}, {"a", "s2"}, {"a.b", "s1"}, {"b", "s1"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte(`"`+key[0]+"/"+key[1]+`"`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func(deviceKey string) (result []string) {
+		err = store.Scan(deviceKey, func(record model.Record) error {
+			if string(record.Value) != `"`+record.DeviceKey+"/"+record.ServiceKey+`"` || record.Time.IsZero() {
+				t.Error(record)
+			}
+			result = append(result, record.DeviceKey+"/"+record.ServiceKey)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		sort.Strings(result)
+		return result
+	}
+	if result := scan(""); !reflect.DeepEqual(result, []string{"a.b/s1", "a/s1", "a/s2", "b/s1"}) {
+		t.Error(result)
+	}
+	if result := scan("a"); !reflect.DeepEqual(result, []string{"a/s1", "a/s2"}) {
+		t.Error(result)
+	}
+	if result := scan("unknown"); len(result) != 0 {
+		t.Error(result)
+	}
+
+	times := []string{}
+	err = store.ScanTimes("a", func(record model.Record) error {
+		if record.Value != nil || record.Time.IsZero() {
+			t.Error(record)
+		}
+		times = append(times, record.DeviceKey+"/"+record.ServiceKey)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(times, []string{"a/s1", "a/s2"}) {
+		t.Error(times, err)
+	}
+}
+
+func TestScanSkipsInvalidRecords(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range []string{"s1", "s3"} {
+		err = store.Set(testRecord("d", service, []byte(service)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(valueKey("d", "s2"), []byte{codec.Version1, 0xff})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scan := range []func(deviceKey string, handler func(record model.Record) error) error{store.Scan, store.ScanTimes} {
+		result := []string{}
+		err = scan("", func(record model.Record) error {
+			result = append(result, record.ServiceKey)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(result, []string{"s1", "s3"}) {
+			t.Error(result, err)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("length", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 3, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []string{"1", "2", "3", "4", "5"} {
+			err = store.Set(testRecord("d", "s", []byte(value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.Set(testRecord("d", "s2", []byte("6")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "3", "4", "5")
+		checkHistory(t, store, "d", "s", time.Time{}, 2, "4", "5")
+		checkHistory(t, store, "d", "s", time.Now(), 0)
+		checkHistory(t, store, "d", "s2", time.Time{}, 0, "6")
+		checkHistory(t, store, "d", "unknown", time.Time{}, 0)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 0, "1h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.historyMaxAge = 100 * time.Millisecond
+		err = store.Set(testRecord("d", "s", []byte("1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		since := time.Now()
+		time.Sleep(200 * time.Millisecond)
+		checkHistory(t, store, "d", "s", time.Time{}, 0)
+		err = store.Set(testRecord("d", "s", []byte("2")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "2")
+		checkHistory(t, store, "d", "s", since, 0, "2")
+	})
+}
+
+func checkHistory(t *testing.T, store *BadgerStore, deviceKey string, serviceKey string, since time.Time, limit int, expected ...string) {
+	t.Helper()
+	history, err := store.History(deviceKey, serviceKey, since, limit)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	actual := []string{}
+	for _, record := range history {
+		actual = append(actual, string(record.Value))
+	}
+	if len(expected) == 0 {
+		expected = []string{}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Error(actual, expected)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := testRecord("d", "s", []byte("1"))
+	err = store.Set(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().Add(time.Minute)
+	err = store.Touch("d", "s", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "s", seen.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "missing", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, found, err := store.Get("d", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(record.Value) != "1" || !record.LastSeen.Equal(seen) || !record.Received.Equal(initial.Received) {
+		t.Error(found, record)
+	}
+	_, found, err = store.Get("d", "missing")
+	if err != nil || found {
+		t.Error(found, err)
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "1")
+}
+
+func TestSetIfNewerConcurrent(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	writers := sync.WaitGroup{}
+	for _, i := range rand.Perm(50) {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			record := testRecord("d", "s", []byte(strconv.Itoa(i)))
+			record.Time = base.Add(time.Duration(i) * time.Second)
+			_, err := store.SetIfNewer(record)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	writers.Wait()
+	checkValue(t, store, "d", "s", "49")
+
+	record := testRecord("d", "s", []byte("old"))
+	record.Time = base
+	stored, err := store.SetIfNewer(record)
+	if err != nil || stored {
+		t.Error(stored, err)
+	}
+	checkValue(t, store, "d", "s", "49")
+
+	history, err := store.History("d", "s", time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 51 || string(history[0].Value) != "0" || string(history[50].Value) != "49" {
+		t.Error(len(history))
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	location := t.TempDir()
+	key := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	open := func(key []byte, f func(store *BadgerStore)) error {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, Tuning{}, false, "3h", "", "", 0, key, 0, "")
+		if err != nil {
+			return err
+		}
+		f(store)
+		return nil
+	}
+	err := open(key, func(store *BadgerStore) {
+		err := store.Set(testRecord("d", "s", []byte("1")))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open(nil, func(store *BadgerStore) {})
+	if err == nil {
+		t.Error("expected error without key")
+	}
+	err = RotateKey(location, Tuning{}, key, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open(key, func(store *BadgerStore) {})
+	if err == nil {
+		t.Error("expected error with old key")
+	}
+	err = open(newKey, func(store *BadgerStore) {
+		checkValue(t, store, "d", "s", "1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptionRewrite(t *testing.T) {
+	location := t.TempDir()
+	key := bytes.Repeat([]byte{1}, 32)
+	payload := []byte("plaintext-payload-marker")
+	open := func(key []byte, f func(store *BadgerStore)) error {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, Tuning{}, false, "3h", "", "", 0, key, 0, "")
+		if err != nil {
+			return err
+		}
+		f(store)
+		return nil
+	}
+	containsPayload := func() (result bool) {
+		err := filepath.Walk(location, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			result = result || bytes.Contains(content, payload)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	err := open(nil, func(store *BadgerStore) {
+		err := store.Set(testRecord("d", "s", payload))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsPayload() {
+		t.Fatal("expected plaintext payload in unencrypted database")
+	}
+
+	err = RotateKey(location, Tuning{}, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsPayload() {
+		t.Error("plaintext payload left after enabling the encryption")
+	}
+	err = open(nil, func(store *BadgerStore) {})
+	if err == nil {
+		t.Error("expected error without key")
+	}
+	err = open(key, func(store *BadgerStore) {
+		checkValue(t, store, "d", "s", string(payload))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RotateKey(location, Tuning{}, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open(nil, func(store *BadgerStore) {
+		checkValue(t, store, "d", "s", string(payload))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(location + ".old"); !os.IsNotExist(err) {
+		t.Error("expected removed backup directory", err)
+	}
+}
+
+func TestTuning(t *testing.T) {
+	t.Run("options", func(t *testing.T) {
+		options, err := Tuning{Profile: ProfileLowMemory, BlockCacheSize: 1 << 20, Compression: "zstd"}.options("location", bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options.MemTableSize != 8<<20 || options.BlockCacheSize != 1<<20 || options.IndexCacheSize != lowMemoryIndexCacheSize || options.Dir != "location" {
+			t.Error(describeOptions(ProfileLowMemory, options))
+		}
+		options, err = Tuning{MemTableSize: 1 << 20, InMemory: true}.options("location", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !options.InMemory || options.Dir != "" || options.IndexCacheSize != 0 || options.ValueThreshold > options.MemTableSize*15/100 {
+			t.Error(describeOptions(ProfileDefault, options))
+		}
+		_, err = Tuning{Profile: "unknown"}.options("location", nil)
+		if err == nil {
+			t.Error("expected error for unknown profile")
+		}
+		_, err = Tuning{Compression: "unknown"}.options("location", nil)
+		if err == nil {
+			t.Error("expected error for unknown compression")
+		}
+	})
+	for name, tuning := range map[string]Tuning{
+		"low memory":           {Profile: ProfileLowMemory},
+		"in memory":            {Profile: ProfileLowMemory, InMemory: true},
+		"small mem table":      {MemTableSize: 1 << 20, ValueLogFileSize: 1 << 20, NumCompactors: 2},
+		"uncompressed":         {Compression: "none", BlockCacheSize: 1 << 20},
+		"low memory encrypted": {Profile: ProfileLowMemory, IndexCacheSize: 1 << 20},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			defer wg.Wait()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var key []byte
+			if name == "low memory encrypted" {
+				key = bytes.Repeat([]byte{1}, 32)
+			}
+			store, err := New(ctx, wg, t.TempDir(), tuning, false, "3h", "", "", 0, key, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				err = store.Set(testRecord("d", "s"+strconv.Itoa(i), bytes.Repeat([]byte("v"), i*100)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkValue(t, store, "d", "s99", strings.Repeat("v", 9900))
+			if _, err = store.Maintain(); tuning.InMemory != errors.Is(err, model.ErrNotSupported) {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			err = store.Set(testRecord("d", "s"+strconv.Itoa(j), []byte(strconv.Itoa(i))))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//concurrent runs (e.g. with the periodic gc) wait for each other instead of failing with badger.ErrRejected
+	results := make(chan model.MaintenanceResult, 10)
+	runs := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			result, err := store.Maintain()
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result
+		}()
+	}
+	runs.Wait()
+	close(results)
+	for result := range results {
+		if result.Operation != "gc" || result.Error != "" || result.SizeBefore == 0 {
+			t.Error(result)
+		}
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Backend != "badger" || stats.Values != 10 || stats.HistoryEntries != 20 || stats.Badger.VlogSize == 0 || stats.Badger.LastGc == nil || stats.DiskSize != stats.Badger.LsmSize+stats.Badger.VlogSize {
+		t.Errorf("%#v %#v", stats, stats.Badger)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	location := t.TempDir()
+	open := func(key []byte) error {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, Tuning{}, true, "3h", "", "", 0, key, 0, "")
+		if err != nil {
+			return err
+		}
+		return store.Set(testRecord("d", "s", []byte("1")))
+	}
+	err := open(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open(bytes.Repeat([]byte{2}, 32))
+	if err == nil || errors.Is(err, storage.ErrCorrupt) {
+		t.Error("wrong key is no corruption", err)
+	}
+	err = os.WriteFile(filepath.Join(location, "file"), nil, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	_, err = New(context.Background(), wg, filepath.Join(location, "file"), Tuning{}, true, "3h", "", "", 0, nil, 0, "")
+	if err == nil || errors.Is(err, storage.ErrCorrupt) {
+		t.Error("file instead of a directory is no corruption", err)
+	}
+	err = os.WriteFile(filepath.Join(location, "MANIFEST"), bytes.Repeat([]byte("garbage"), 100), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = open(bytes.Repeat([]byte{1}, 32))
+	if !errors.Is(err, storage.ErrCorrupt) {
+		t.Error(err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"d", "s"}, {"d", "s2"}, {"d", "s3"}, {"d2", "s"}, {"d2", "s2"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte(key[0]+key[1])))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the value key of "s" is a prefix of the value key of "s2"
+	deleted, err := store.Delete("d", "s")
+	if err != nil || !deleted {
+		t.Error(deleted, err)
+	}
+	deleted, err = store.Delete("d", "s")
+	if err != nil || deleted {
+		t.Error(deleted, err)
+	}
+	checkMissing(t, store, "d", "s")
+	checkHistory(t, store, "d", "s", time.Time{}, 0)
+	checkValue(t, store, "d", "s2", "ds2")
+	checkHistory(t, store, "d", "s2", time.Time{}, 0, "ds2")
+
+	count, err := store.DeletePrefix("d")
+	if err != nil || count != 2 {
+		t.Error(count, err)
+	}
+	for _, service := range []string{"s2", "s3"} {
+		checkMissing(t, store, "d", service)
+		checkHistory(t, store, "d", service, time.Time{}, 0)
+	}
+	checkValue(t, store, "d2", "s", "d2s")
+	checkValue(t, store, "d2", "s2", "d2s2")
+	checkHistory(t, store, "d2", "s", time.Time{}, 0, "d2s")
+
+	count, err = store.DeletePrefix("d")
+	if err != nil || count != 0 {
+		t.Error(count, err)
+	}
+}
+
+func TestSetIfNewerFutureTime(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, t.TempDir(), Tuning{}, false, "3h", "", "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a wrong future time must not block later values
+	future := testRecord("d", "s", []byte("future"))
+	future.Received = future.Received.Add(-time.Hour)
+	future.Time = future.Received.Add(24 * time.Hour)
+	tolerated := testRecord("d", "s", []byte("tolerated"))
+	tolerated.Time = tolerated.Received.Add(model.MaxFutureTime / 2)
+	older := testRecord("d", "s", []byte("older"))
+	older.Time = older.Time.Add(-time.Minute)
+	for _, step := range []struct {
+		record model.Record
+		stored bool
+	}{{future, true}, {testRecord("d", "s", []byte("now")), true}, {tolerated, true}, {older, false}} {
+		stored, err := store.SetIfNewer(step.record)
+		if err != nil || stored != step.stored {
+			t.Error(string(step.record.Value), stored, err)
+		}
+	}
+	checkValue(t, store, "d", "s", "tolerated")
+}
+
+func checkValue(t *testing.T, store *BadgerStore, deviceKey string, serviceKey string, expected string) {
+	t.Helper()
+	record, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !found || string(record.Value) != expected || record.Time.IsZero() {
+		t.Error(deviceKey, serviceKey, found, record, expected)
+	}
+}
+
+func checkMissing(t *testing.T, store *BadgerStore, deviceKey string, serviceKey string) {
+	t.Helper()
+	_, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found {
+		t.Error("unexpected value for", deviceKey, serviceKey)
+	}
+}
+
+func testRecord(deviceKey string, serviceKey string, value []byte) model.Record {
+	now := time.Now()
+	return model.Record{DeviceKey: deviceKey, ServiceKey: serviceKey, Value: value, Time: now, Received: now}
+}
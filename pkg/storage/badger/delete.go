@@ -0,0 +1,69 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Delete removes the value of the device service and its history; deleted is false if no value was stored
+func (this *BadgerStore) Delete(deviceKey string, serviceKey string) (deleted bool, err error) {
+	count, err := this.deletePrefixes(valueKey(deviceKey, serviceKey), true, historyPrefix(deviceKey, serviceKey))
+	return count > 0, err
+}
+
+// DeletePrefix removes all values of the device and their history; deleted is the number of removed values
+func (this *BadgerStore) DeletePrefix(deviceKey string) (deleted int, err error) {
+	return this.deletePrefixes(devicePrefix(deviceKey), false, historyDevicePrefix(deviceKey))
+}
+
+// deletePrefixes deletes all keys with the value or history prefix and returns the number of deleted values;
+// with exact, only the value key equal to valuePrefix is deleted (value keys of other services may start with it).
+// histories may exceed the transaction size, so the keys are deleted in a write batch.
+func (this *BadgerStore) deletePrefixes(valuePrefix []byte, exact bool, historyPrefix []byte) (deleted int, err error) {
+	keys := [][]byte{}
+	err = this.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.PrefetchValues = false
+		it := txn.NewIterator(options)
+		defer it.Close()
+		for it.Seek(valuePrefix); it.ValidForPrefix(valuePrefix); it.Next() {
+			if exact && !bytes.Equal(it.Item().Key(), valuePrefix) {
+				break
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		deleted = len(keys)
+		for it.Seek(historyPrefix); it.ValidForPrefix(historyPrefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	batch := this.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		err = batch.Delete(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return deleted, batch.Flush()
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"io"
+	"log"
+	"os"
+)
+
+// RotateKey changes the encryption key of the closed database at location from oldKey to newKey.
+// if both keys are set, only the key registry is re-encrypted: the data files are encrypted with data keys from the registry.
+// if the encryption is enabled (oldKey is nil) or removed (newKey is nil), the data files have to be rewritten:
+// the database is copied with newKey to a temporary directory next to location, which then replaces location.
+// this needs free disk space for a second copy of the data.
+func RotateKey(location string, tuning Tuning, oldKey []byte, newKey []byte) error {
+	if len(oldKey) > 0 && len(newKey) > 0 {
+		return rotateRegistry(location, oldKey, newKey)
+	}
+	return rewrite(location, tuning, oldKey, newKey)
+}
+
+func rotateRegistry(location string, oldKey []byte, newKey []byte) error {
+	registry, err := badger.OpenKeyRegistry(badger.KeyRegistryOptions{
+		Dir:           location,
+		ReadOnly:      true,
+		EncryptionKey: oldKey,
+	})
+	if err != nil {
+		return err
+	}
+	defer registry.Close()
+	return badger.WriteKeyRegistry(registry, badger.KeyRegistryOptions{
+		Dir:           location,
+		EncryptionKey: newKey,
+	})
+}
+
+// rewrite copies all current values of the database at location into a new database encrypted with newKey
+// and replaces location with it; expired and deleted values are not copied.
+func rewrite(location string, tuning Tuning, oldKey []byte, newKey []byte) error {
+	tuning.InMemory = false
+	target := location + ".rotate"
+	backup := location + ".old"
+	err := os.RemoveAll(target)
+	if err != nil {
+		return errors.New("unable to remove leftover " + target + ":" + err.Error())
+	}
+	err = copyDatabase(location, target, tuning, oldKey, newKey)
+	if err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+	err = os.Rename(location, backup)
+	if err != nil {
+		os.RemoveAll(target)
+		return errors.New("unable to move " + location + ":" + err.Error())
+	}
+	err = os.Rename(target, location)
+	if err != nil {
+		if restoreErr := os.Rename(backup, location); restoreErr != nil {
+			log.Println("ERROR: unable to restore", location, "from", backup, restoreErr)
+		}
+		return errors.New("unable to move " + target + " to " + location + ":" + err.Error())
+	}
+	err = os.RemoveAll(backup)
+	if err != nil {
+		log.Println("WARNING: unable to remove", backup, err)
+	}
+	return nil
+}
+
+func copyDatabase(source string, target string, tuning Tuning, sourceKey []byte, targetKey []byte) error {
+	sourceOptions, err := tuning.options(source, sourceKey)
+	if err != nil {
+		return err
+	}
+	sourceDb, err := badger.Open(sourceOptions)
+	if err != nil {
+		return errors.New("unable to open " + source + ":" + err.Error())
+	}
+	defer sourceDb.Close()
+	targetOptions, err := tuning.options(target, targetKey)
+	if err != nil {
+		return err
+	}
+	targetDb, err := badger.Open(targetOptions)
+	if err != nil {
+		return errors.New("unable to open " + target + ":" + err.Error())
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := sourceDb.Backup(writer, 0)
+		writer.CloseWithError(err)
+	}()
+	err = targetDb.Load(reader, 256)
+	reader.CloseWithError(err) //unblocks the backup if the load failed
+	<-done
+	if err != nil {
+		targetDb.Close()
+		return errors.New("unable to copy " + source + ":" + err.Error())
+	}
+	return targetDb.Close()
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/dgraph-io/badger/v3"
+	"time"
+)
+
+func (this *BadgerStore) historyEnabled() bool {
+	return this.historyLength > 0 || this.historyMaxAge > 0
+}
+
+func (this *BadgerStore) appendHistory(txn *badger.Txn, deviceKey string, serviceKey string, t time.Time, encoded []byte) error {
+	if !this.historyEnabled() {
+		return nil
+	}
+	seq, err := this.historySeq.Next()
+	if err != nil {
+		return err
+	}
+	entry := badger.NewEntry(historyKey(deviceKey, serviceKey, t, seq), encoded)
+	if this.ttl != 0 {
+		entry.WithTTL(this.ttl)
+	}
+	err = txn.SetEntry(entry)
+	if err != nil {
+		return err
+	}
+	return this.trimHistory(txn, deviceKey, serviceKey)
+}
+
+// trimHistory removes entries older than historyMaxAge and the oldest entries exceeding historyLength
+func (this *BadgerStore) trimHistory(txn *badger.Txn, deviceKey string, serviceKey string) error {
+	options := badger.DefaultIteratorOptions
+	options.PrefetchValues = false
+	options.Prefix = historyPrefix(deviceKey, serviceKey)
+	it := txn.NewIterator(options)
+	keys := [][]byte{}
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	remove := 0
+	if this.historyLength > 0 && len(keys) > this.historyLength {
+		remove = len(keys) - this.historyLength
+	}
+	if this.historyMaxAge > 0 {
+		limit := historyKey(deviceKey, serviceKey, time.Now().Add(-this.historyMaxAge), 0)
+		for remove < len(keys) && bytes.Compare(keys[remove], limit) < 0 {
+			remove++
+		}
+	}
+	for _, key := range keys[:remove] {
+		err := txn.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the stored history entries of a device and service since the given time (and within historyMaxAge) in chronological order.
+// if limit > 0, only the newest limit entries are returned.
+func (this *BadgerStore) History(deviceKey string, serviceKey string, since time.Time, limit int) (result []model.Record, err error) {
+	//entries older than historyMaxAge are only removed by the next write to the key
+	if this.historyMaxAge > 0 && since.Before(time.Now().Add(-this.historyMaxAge)) {
+		since = time.Now().Add(-this.historyMaxAge)
+	}
+	result = []model.Record{}
+	err = this.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.Prefix = historyPrefix(deviceKey, serviceKey)
+		it := txn.NewIterator(options)
+		defer it.Close()
+		for it.Seek(historyKey(deviceKey, serviceKey, since, 0)); it.Valid(); it.Next() {
+			record, err := decodeValue(deviceKey, serviceKey, it.Item())
+			if err != nil {
+				return err
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, err
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// keys are namespaced by their first byte to separate values from meta information
+const (
+	metaKeyPrefix    byte = 0
+	valueKeyPrefix   byte = 1
+	historyKeyPrefix byte = 2
+)
+
+// keyFormatKey stores the version of the key encoding; it is missing in databases of older versions
+var keyFormatKey = []byte{metaKeyPrefix, 'k', 'e', 'y', '_', 'f', 'o', 'r', 'm', 'a', 't'}
+
+const keyFormatVersion = "1"
+
+// valueKey encodes device and service unambiguously as
+// valueKeyPrefix | uint16 big endian len(device) | device | service
+// mqtt topics are limited to 65535 bytes, so the device length always fits
+func valueKey(deviceKey string, serviceKey string) []byte {
+	result := devicePrefix(deviceKey)
+	return append(result, serviceKey...)
+}
+
+// devicePrefix is the common prefix of all value keys of a device
+func devicePrefix(deviceKey string) []byte {
+	result := make([]byte, 0, 3+len(deviceKey))
+	result = append(result, valueKeyPrefix)
+	result = binary.BigEndian.AppendUint16(result, uint16(len(deviceKey)))
+	return append(result, deviceKey...)
+}
+
+// parseValueKey is the inverse of valueKey
+func parseValueKey(key []byte) (deviceKey string, serviceKey string, ok bool) {
+	if len(key) < 3 || key[0] != valueKeyPrefix {
+		return "", "", false
+	}
+	length := int(binary.BigEndian.Uint16(key[1:3]))
+	if len(key) < 3+length {
+		return "", "", false
+	}
+	return string(key[3 : 3+length]), string(key[3+length:]), true
+}
+
+// historySequenceKey stores the badger.Sequence used to distinguish history entries with the same time
+var historySequenceKey = []byte{metaKeyPrefix, 'h', 'i', 's', 't', 'o', 'r', 'y', '_', 's', 'e', 'q'}
+
+// historyPrefix encodes device and service unambiguously as
+// historyKeyPrefix | uint16 big endian len(device) | device | uint16 big endian len(service) | service
+func historyPrefix(deviceKey string, serviceKey string) []byte {
+	result := make([]byte, 0, 5+len(deviceKey)+len(serviceKey)+16)
+	result = append(result, historyDevicePrefix(deviceKey)...)
+	result = binary.BigEndian.AppendUint16(result, uint16(len(serviceKey)))
+	return append(result, serviceKey...)
+}
+
+// historyDevicePrefix is the common prefix of all history keys of a device
+func historyDevicePrefix(deviceKey string) []byte {
+	result := make([]byte, 0, 3+len(deviceKey))
+	result = append(result, historyKeyPrefix)
+	result = binary.BigEndian.AppendUint16(result, uint16(len(deviceKey)))
+	return append(result, deviceKey...)
+}
+
+// historyKey appends the time and a sequence number to the historyPrefix, to order entries by time.
+// times before 1970 are mapped to 1970.
+func historyKey(deviceKey string, serviceKey string, t time.Time, seq uint64) []byte {
+	nanos := uint64(0)
+	if t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+	result := binary.BigEndian.AppendUint64(historyPrefix(deviceKey, serviceKey), nanos)
+	return binary.BigEndian.AppendUint64(result, seq)
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
+	"github.com/dgraph-io/badger/v3"
+	"log"
+	"strings"
+	"sync"
+)
+
+// migrateLegacyKeys rewrites values stored by older versions with "device.service" keys to the
+// length-prefixed encoding of valueKey. expiration times of the legacy entries are kept.
+// legacy keys are ambiguous and are split at the last '.'
+func (this *BadgerStore) migrateLegacyKeys() error {
+	migrated := false
+	err := this.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(keyFormatKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		migrated = err == nil
+		return err
+	})
+	if err != nil || migrated {
+		return err
+	}
+
+	log.Println("migrate legacy badger keys")
+	batch := this.db.NewWriteBatch()
+	defer batch.Cancel()
+	count := 0
+	err = this.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+			index := strings.LastIndex(key, ".")
+			if index <= 0 || index == len(key)-1 {
+				log.Println("WARNING: drop legacy badger key without device or service part:", key)
+			} else {
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				entry := badger.NewEntry(valueKey(key[:index], key[index+1:]), value)
+				entry.ExpiresAt = item.ExpiresAt()
+				err = batch.SetEntry(entry)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			err := batch.Delete(item.KeyCopy(nil))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = batch.Set(keyFormatKey, []byte(keyFormatVersion))
+	if err != nil {
+		return err
+	}
+	err = batch.Flush()
+	if err != nil {
+		return err
+	}
+	log.Println("migrated", count, "legacy badger keys")
+	return nil
+}
+
+// recordFormatKey stores the codec format of all records (see codec.Codec.Format)
+var recordFormatKey = []byte{metaKeyPrefix, 'r', 'e', 'c', 'o', 'r', 'd', '_', 'f', 'o', 'r', 'm', 'a', 't'}
+
+const recordUpgradeBatchSize = 1000
+
+// startRecordUpgrade rewrites values and history entries of older versions in the current binary encoding
+// in the background. reads handle both encodings, so the upgrade may be interrupted by a shutdown;
+// it continues on the next start. when all records are upgraded, a marker skips the upgrade on later starts.
+func (this *BadgerStore) startRecordUpgrade(ctx context.Context, wg *sync.WaitGroup) (done chan struct{}) {
+	done = make(chan struct{})
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		defer close(done)
+		upgraded, err := this.upgradeRecords(ctx)
+		if err != nil {
+			log.Println("ERROR: unable to upgrade badger records:", err)
+			return
+		}
+		if upgraded > 0 {
+			log.Println("upgraded", upgraded, "badger records to the binary record format")
+		}
+	}()
+	return done
+}
+
+func (this *BadgerStore) upgradeRecords(ctx context.Context) (upgraded int, err error) {
+	done := false
+	err = this.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(recordFormatKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			done = string(val) == this.codec.Format()
+			return nil
+		})
+	})
+	if err != nil || done {
+		return 0, err
+	}
+	for _, prefix := range []byte{valueKeyPrefix, historyKeyPrefix} {
+		var next []byte
+		for more := true; more; {
+			if ctx.Err() != nil {
+				return upgraded, nil
+			}
+			var keys [][]byte
+			keys, next, more, err = this.findLegacyRecords(prefix, next)
+			if err != nil {
+				return upgraded, err
+			}
+			count := 0
+			err = this.update(func(txn *badger.Txn) error {
+				count = 0
+				for _, key := range keys {
+					item, err := txn.Get(key)
+					if err == badger.ErrKeyNotFound {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					var encoded []byte
+					err = item.Value(func(val []byte) error {
+						if !this.codec.NeedsUpgrade(val) {
+							return nil
+						}
+						record, err := codec.Decode("", "", val)
+						if err != nil {
+							return nil //unreadable values are kept
+						}
+						encoded, err = this.encodeValue(record)
+						return err
+					})
+					if err != nil {
+						return err
+					}
+					if encoded == nil {
+						continue
+					}
+					entry := badger.NewEntry(key, encoded)
+					entry.ExpiresAt = item.ExpiresAt()
+					err = txn.SetEntry(entry)
+					if err != nil {
+						return err
+					}
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				return upgraded, err
+			}
+			upgraded += count
+		}
+	}
+	return upgraded, this.update(func(txn *badger.Txn) error {
+		return txn.Set(recordFormatKey, []byte(this.codec.Format()))
+	})
+}
+
+// findLegacyRecords returns up to recordUpgradeBatchSize keys with legacy values, starting at start (nil: beginning of prefix)
+func (this *BadgerStore) findLegacyRecords(prefix byte, start []byte) (keys [][]byte, next []byte, more bool, err error) {
+	err = this.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.Prefix = []byte{prefix}
+		it := txn.NewIterator(options)
+		defer it.Close()
+		if start == nil {
+			it.Rewind()
+		} else {
+			it.Seek(start)
+		}
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			if len(keys) >= recordUpgradeBatchSize {
+				next = item.KeyCopy(nil)
+				more = true
+				return nil
+			}
+			err := item.Value(func(val []byte) error {
+				if this.codec.NeedsUpgrade(val) {
+					keys = append(keys, item.KeyCopy(nil))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return keys, next, more, err
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
+)
+
+const (
+	ProfileDefault   = ""
+	ProfileLowMemory = "low_memory"
+)
+
+// Tuning overrides the badger options of the selected profile; zero values keep the profile value
+type Tuning struct {
+	Profile          string
+	MemTableSize     int64
+	ValueLogFileSize int64
+	BlockCacheSize   int64
+	IndexCacheSize   int64
+	Compression      string //"none", "snappy" or "zstd"; block compression of badger tables
+	NumCompactors    int64
+	InMemory         bool
+}
+
+func TuningFromConfig(config configuration.Config) Tuning {
+	return Tuning{
+		Profile:          config.BadgerProfile,
+		MemTableSize:     config.BadgerMemTableSize,
+		ValueLogFileSize: config.BadgerValueLogFileSize,
+		BlockCacheSize:   config.BadgerBlockCacheSize,
+		IndexCacheSize:   config.BadgerIndexCacheSize,
+		Compression:      config.BadgerCompression,
+		NumCompactors:    config.BadgerNumCompactors,
+		InMemory:         config.BadgerInMemory,
+	}
+}
+
+// encryptedIndexCacheSize is the default size of the block index cache, which badger requires with encryption
+const encryptedIndexCacheSize = 64 << 20
+
+// lowMemoryIndexCacheSize replaces encryptedIndexCacheSize in the low_memory profile
+const lowMemoryIndexCacheSize = 8 << 20
+
+// options returns the badger options for the database at location
+func (this Tuning) options(location string, encryptionKey []byte) (result badger.Options, err error) {
+	result = badger.DefaultOptions(location)
+	indexCacheSize := int64(encryptedIndexCacheSize)
+	switch this.Profile {
+	case ProfileDefault:
+	case ProfileLowMemory:
+		//roughly 50MB instead of more than 500MB with the default options
+		result = result.
+			WithMemTableSize(8 << 20).
+			WithNumMemtables(2).
+			WithNumLevelZeroTables(2).
+			WithNumLevelZeroTablesStall(4).
+			WithValueLogFileSize(16 << 20).
+			WithBlockCacheSize(8 << 20).
+			WithNumCompactors(2)
+		indexCacheSize = lowMemoryIndexCacheSize
+	default:
+		return result, errors.New("unknown badger profile " + this.Profile + "; available: \"\", " + ProfileLowMemory)
+	}
+	if this.MemTableSize > 0 {
+		result = result.WithMemTableSize(this.MemTableSize)
+	}
+	if this.ValueLogFileSize > 0 {
+		result = result.WithValueLogFileSize(this.ValueLogFileSize)
+	}
+	if this.BlockCacheSize > 0 {
+		result = result.WithBlockCacheSize(this.BlockCacheSize)
+	}
+	if this.IndexCacheSize > 0 {
+		indexCacheSize = this.IndexCacheSize
+	}
+	switch this.Compression {
+	case "":
+	case "none":
+		result = result.WithCompression(options.None)
+	case "snappy":
+		result = result.WithCompression(options.Snappy)
+	case "zstd":
+		result = result.WithCompression(options.ZSTD)
+	default:
+		return result, errors.New("unknown badger compression " + this.Compression + "; available: none, snappy, zstd")
+	}
+	if this.NumCompactors > 0 {
+		result = result.WithNumCompactors(int(this.NumCompactors))
+	}
+	if this.InMemory {
+		result = result.WithDir("").WithValueDir("").WithInMemory(true)
+	}
+	if len(encryptionKey) > 0 {
+		result = result.WithEncryptionKey(encryptionKey).WithIndexCacheSize(indexCacheSize)
+	} else if this.IndexCacheSize > 0 {
+		result = result.WithIndexCacheSize(this.IndexCacheSize)
+	}
+
+	//badger rejects values above 15% of the mem table size, which small mem tables would undercut
+	if maxValueThreshold := result.MemTableSize * 15 / 100; result.ValueThreshold > maxValueThreshold {
+		result = result.WithValueThreshold(maxValueThreshold)
+	}
+	return result, nil
+}
+
+func describeOptions(profile string, options badger.Options) string {
+	if profile == ProfileDefault {
+		profile = "default"
+	}
+	return fmt.Sprintf("profile=%v in_memory=%v mem_table_size=%v num_memtables=%v value_log_file_size=%v block_cache_size=%v index_cache_size=%v compression=%v num_compactors=%v value_threshold=%v encrypted=%v",
+		profile,
+		options.InMemory,
+		options.MemTableSize,
+		options.NumMemtables,
+		options.ValueLogFileSize,
+		options.BlockCacheSize,
+		options.IndexCacheSize,
+		compressionName(options.Compression),
+		options.NumCompactors,
+		options.ValueThreshold,
+		len(options.EncryptionKey) > 0)
+}
+
+func compressionName(compression options.CompressionType) string {
+	switch compression {
+	case options.None:
+		return "none"
+	case options.Snappy:
+		return "snappy"
+	case options.ZSTD:
+		return "zstd"
+	default:
+		return fmt.Sprint(compression)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/dgraph-io/badger/v3"
+	"log"
+)
+
+// Scan calls handler for every stored value of deviceKey or, if deviceKey is empty, of all devices.
+// records of a device are visited ordered by service. handler must not access the store.
+// records that can not be decoded are logged and skipped.
+func (this *BadgerStore) Scan(deviceKey string, handler func(record model.Record) error) error {
+	return this.scan(deviceKey, decodeValue, handler)
+}
+
+// ScanTimes calls handler like Scan, but the records contain only keys and times (Value is nil); payloads are not decoded
+func (this *BadgerStore) ScanTimes(deviceKey string, handler func(record model.Record) error) error {
+	return this.scan(deviceKey, decodeTimes, handler)
+}
+
+// scan skips records that can not be decoded; decode logs the errors, scan the number of skipped records
+func (this *BadgerStore) scan(deviceKey string, decode func(deviceKey string, serviceKey string, item *badger.Item) (model.Record, error), handler func(record model.Record) error) error {
+	prefix := []byte{valueKeyPrefix}
+	if deviceKey != "" {
+		prefix = devicePrefix(deviceKey)
+	}
+	skipped := 0
+	defer func() {
+		if skipped > 0 {
+			log.Println("WARNING: badger scan skipped", skipped, "records that could not be decoded")
+		}
+	}()
+	return this.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.Prefix = prefix
+		it := txn.NewIterator(options)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			device, service, ok := parseValueKey(item.Key())
+			if !ok {
+				log.Println("WARNING: skip invalid badger key", item.Key())
+				continue
+			}
+			record, err := decode(device, service, item)
+			if err != nil {
+				skipped++
+				continue
+			}
+			err = handler(record)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/dgraph-io/badger/v3"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// gcDiscardRatio is the share of stale data at which a value log file is rewritten
+const gcDiscardRatio = 0.5
+
+func (this *BadgerStore) Stats() (result model.StorageStats, err error) {
+	result.Backend = "badger"
+	err = this.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.PrefetchValues = false
+		it := txn.NewIterator(options)
+		defer it.Close()
+		for it.Seek([]byte{valueKeyPrefix}); it.ValidForPrefix([]byte{valueKeyPrefix}); it.Next() {
+			result.Values++
+		}
+		for it.Seek([]byte{historyKeyPrefix}); it.ValidForPrefix([]byte{historyKeyPrefix}); it.Next() {
+			result.HistoryEntries++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	compression := this.codec.Stats()
+	result.Compression = &compression
+	result.Badger = &model.BadgerStats{}
+	result.Badger.LsmSize, result.Badger.VlogSize = this.size()
+	result.DiskSize = result.Badger.LsmSize + result.Badger.VlogSize
+	this.gcMux.Lock()
+	result.Badger.LastGc = this.lastGc
+	this.gcMux.Unlock()
+	return result, nil
+}
+
+// size returns the current size of the lsm tree and the value log files; badger.DB.Size is only updated every minute
+func (this *BadgerStore) size() (lsm int64, vlog int64) {
+	if this.location == "" {
+		return 0, 0
+	}
+	entries, err := os.ReadDir(this.location)
+	if err != nil {
+		log.Println("WARNING: unable to read badger directory:", err)
+		return 0, 0
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".sst":
+			lsm += info.Size()
+		case ".vlog":
+			vlog += info.Size()
+		}
+	}
+	return lsm, vlog
+}
+
+// Maintain runs the value log gc (see GC); in memory, there is no value log to collect
+func (this *BadgerStore) Maintain() (model.MaintenanceResult, error) {
+	if this.location == "" {
+		return model.MaintenanceResult{}, model.ErrNotSupported
+	}
+	return this.GC()
+}
+
+// GC rewrites value log files until no file has enough stale data; the result is reported by Stats.
+// the periodic gc of badger_gc_interval uses the same method; concurrent calls wait for the running gc.
+func (this *BadgerStore) GC() (result model.MaintenanceResult, err error) {
+	this.gcRun.Lock()
+	defer this.gcRun.Unlock()
+	result.Operation = "gc"
+	result.Start = time.Now()
+	_, result.SizeBefore = this.size()
+	for err == nil {
+		err = this.db.RunValueLogGC(gcDiscardRatio)
+		if err == nil {
+			result.Rewritten++
+		}
+	}
+	if errors.Is(err, badger.ErrNoRewrite) {
+		err = nil
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	_, result.SizeAfter = this.size()
+	result.Duration = time.Since(result.Start).String()
+	this.gcMux.Lock()
+	this.lastGc = &result
+	this.gcMux.Unlock()
+	return result, err
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/dgraph-io/badger/v3/y"
+	"log"
+	"strings"
+	"time"
+)
+
+// verify checks the checksums of all tables
+func (this *BadgerStore) verify() error {
+	start := time.Now()
+	err := this.db.VerifyChecksum()
+	if err != nil {
+		return errors.New("badger checksum verification failed: " + err.Error())
+	}
+	log.Println("verified badger tables in", time.Since(start))
+	return nil
+}
+
+// corruptionMessages identify errors of badger.Open caused by damaged manifest, table or value log files.
+// most of these errors are not exported by badger.
+var corruptionMessages = []string{
+	"manifest has bad magic",
+	"manifest has checksum mismatch",
+	"manifest has unsupported version",
+	"MANIFEST invalid",
+	"MANIFEST removes non-existing table",
+	"MANIFEST file has invalid manifestChange op",
+	"file does not exist for table",
+	"checksum mismatch",
+	"Data corrupted",
+}
+
+// openError marks errors of badger.Open as corrupt if they are caused by damaged files.
+// all other errors (e.g. missing permissions, a full or read-only file system, too many open files,
+// a directory locked by another process, a wrong encryption key or an invalid option) are returned unchanged.
+func openError(err error) error {
+	if errors.Is(err, y.ErrChecksumMismatch) {
+		return storage.Corrupt(err)
+	}
+	message := err.Error()
+	for _, corruption := range corruptionMessages {
+		if strings.Contains(message, corruption) {
+			return storage.Corrupt(err)
+		}
+	}
+	return err
+}
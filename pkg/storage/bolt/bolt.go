@@ -18,34 +18,105 @@ package bolt
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
 	"go.etcd.io/bbolt"
 	"log"
 	"sync"
 	"time"
 )
 
-var BBOLT_BUCKET_NAME = []byte("last_value")
+// BBOLT_BUCKET_NAME is the root bucket; it contains one nested bucket per device, keyed by service
+var BBOLT_BUCKET_NAME = []byte("devices")
+
+// BBOLT_LEGACY_BUCKET_NAME is the flat bucket used by older versions with "device.service" keys
+var BBOLT_LEGACY_BUCKET_NAME = []byte("last_value")
 
 type Store struct {
-	db *bbolt.DB
+	db            *bbolt.DB
+	dbMux         sync.RWMutex //guards db against the replacement by Compact
+	location      string
+	historyLength int
+	historyMaxAge time.Duration
+	ttl           time.Duration
+	buffer        *writeBuffer //nil if writes are not buffered
+	codec         *codec.Codec
+	upgradeDone   chan struct{}
+
+	lastCompaction *model.MaintenanceResult //guarded by dbMux
+	compactMux     sync.Mutex               //serializes Compact calls
+	changesMux     sync.Mutex
+	changes        map[string]map[string]bool //keys written during a running Compact by root bucket (see changed); nil if no Compact is running
 }
 
 func NewWithConfig(ctx context.Context, wg *sync.WaitGroup, config configuration.Config) (result *Store, err error) {
-	return New(ctx, wg, config.BoltLocation)
+	encryptionKey, err := codec.LoadKey(config.EncryptionKey, config.EncryptionKeyFile)
+	if err != nil {
+		return result, err
+	}
+	return New(ctx, wg, config.BoltLocation, config.StorageVerify, config.Ttl, config.BoltTtlSweepInterval, config.BoltWriteBufferInterval, config.BoltWriteBufferSize, config.Compression, config.CompressionThreshold, encryptionKey, config.HistoryLength, config.HistoryMaxAge)
 }
 
-func New(ctx context.Context, wg *sync.WaitGroup, location string) (result *Store, err error) {
+// New opens the bolt file at location and, if verify is set, checks its consistency; if ttlStr is set, values expire
+// ttl after they were last seen (see Expired) and are removed by a sweeper running every sweepIntervalStr.
+// if bufferIntervalStr is set, writes are buffered and committed every bufferIntervalStr
+// or when bufferSize writes are buffered (bufferSize <= 0: only by interval).
+// payloads larger than compressionThreshold bytes are compressed with compression ("", "gzip" or "zstd").
+// with encryptionKey, records are encrypted with AES-GCM (see codec.FlagEncrypted).
+func New(ctx context.Context, wg *sync.WaitGroup, location string, verify bool, ttlStr string, sweepIntervalStr string, bufferIntervalStr string, bufferSize int64, compression string, compressionThreshold int64, encryptionKey []byte, historyLength int64, historyMaxAgeStr string) (result *Store, err error) {
 	log.Println("start bolt")
-	result = &Store{}
-	result.db, err = bbolt.Open(location, 0666, nil)
+	result = &Store{location: location, historyLength: int(historyLength)}
+	result.codec, err = codec.NewCodec(compression, compressionThreshold, encryptionKey)
+	if err != nil {
+		return result, err
+	}
+	var sweepInterval time.Duration
+	if ttlStr != "" {
+		result.ttl, err = time.ParseDuration(ttlStr)
+		if err != nil {
+			return result, errors.New("unable to parse ttl as duration:" + err.Error())
+		}
+		sweepInterval, err = time.ParseDuration(sweepIntervalStr)
+		if err != nil {
+			return result, errors.New("unable to parse bolt ttl sweep interval as duration:" + err.Error())
+		}
+	}
+	var bufferInterval time.Duration
+	if bufferIntervalStr != "" {
+		bufferInterval, err = time.ParseDuration(bufferIntervalStr)
+		if err != nil {
+			return result, errors.New("unable to parse bolt write buffer interval as duration:" + err.Error())
+		}
+		result.buffer = newWriteBuffer(int(bufferSize))
+	}
+	if historyMaxAgeStr != "" {
+		result.historyMaxAge, err = time.ParseDuration(historyMaxAgeStr)
+		if err != nil {
+			return result, errors.New("unable to parse history max age as duration:" + err.Error())
+		}
+	}
+	result.db, err = open(location)
 	if err != nil {
 		return result, err
 	}
 
+	if verify {
+		err = result.verify()
+		if err != nil {
+			result.db.Close()
+			return result, storage.Corrupt(err)
+		}
+	}
+
 	err = result.db.Update(func(tx *bbolt.Tx) error {
 		_, err = tx.CreateBucketIfNotExists(BBOLT_BUCKET_NAME)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(BBOLT_HISTORY_BUCKET_NAME)
 		return err
 	})
 	if err != nil {
@@ -53,6 +124,20 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string) (result *Stor
 		return result, err
 	}
 
+	err = result.migrateLegacyKeys()
+	if err != nil {
+		result.db.Close()
+		return result, err
+	}
+
+	err = result.checkEncryptionKey()
+	if err != nil {
+		result.db.Close()
+		return result, err
+	}
+
+	result.upgradeDone = result.startRecordUpgrade(ctx, wg)
+
 	if wg != nil {
 		wg.Add(1)
 	}
@@ -61,46 +146,190 @@ func New(ctx context.Context, wg *sync.WaitGroup, location string) (result *Stor
 			defer wg.Done()
 		}
 		<-ctx.Done()
+		<-result.upgradeDone
+		err = result.Flush()
+		if err != nil {
+			log.Println("ERROR: unable to flush bolt write buffer on shutdown:", err)
+		}
+		result.dbMux.Lock()
 		err = result.db.Close()
+		result.dbMux.Unlock()
 		if err != nil {
 			log.Println("WARNING: unable to close bolt file:", err)
 		}
 	}()
 
+	result.codec.StartStatsLog(ctx, wg, "bolt", time.Hour)
+
+	if result.buffer != nil {
+		result.startWriteBuffer(ctx, wg, bufferInterval)
+	}
+
+	if result.ttl > 0 {
+		result.startSweeper(ctx, wg, sweepInterval)
+	}
+
 	return result, nil
 }
 
-type ValueWithTime struct {
-	Value []byte    `json:"v"`
-	Time  time.Time `json:"t"`
+// view runs f in a read transaction; like all accesses to db, it waits while Compact replaces db
+func (this *Store) view(f func(tx *bbolt.Tx) error) error {
+	this.dbMux.RLock()
+	defer this.dbMux.RUnlock()
+	return this.db.View(f)
+}
+
+// update runs f in a write transaction; like all accesses to db, it waits while Compact replaces db.
+// writes have to be recorded with changed, so that Compact copies them
+func (this *Store) update(f func(tx *bbolt.Tx) error) error {
+	this.dbMux.RLock()
+	defer this.dbMux.RUnlock()
+	return this.db.Update(f)
+}
+
+func (this *Store) encodeValue(record model.Record) ([]byte, error) {
+	return this.codec.Encode(record)
 }
 
-func (this *Store) Set(key string, value []byte) error {
-	jsonValue, err := json.Marshal(ValueWithTime{Value: value, Time: time.Now()})
+func (this *Store) decodeValue(deviceKey string, serviceKey string, value []byte) (record model.Record, err error) {
+	record, err = this.codec.Decode(deviceKey, serviceKey, value)
 	if err != nil {
-		return err
+		log.Println("ERROR: unable to decode value from bolt", deviceKey, serviceKey, err)
+	}
+	return record, err
+}
+
+func (this *Store) decodeTimes(deviceKey string, serviceKey string, value []byte) (record model.Record, err error) {
+	record, err = this.codec.DecodeTimes(deviceKey, serviceKey, value)
+	if err != nil {
+		log.Println("ERROR: unable to decode value from bolt", deviceKey, serviceKey, err)
+	}
+	return record, err
+}
+
+func (this *Store) Set(record model.Record) error {
+	_, err := this.set(record, false)
+	return err
+}
+
+// SetIfNewer replaces the last value only if the stored value is not newer than the record (see model.Record.OrderTime).
+// the record is added to the history in both cases.
+func (this *Store) SetIfNewer(record model.Record) (stored bool, err error) {
+	return this.set(record, true)
+}
+
+func (this *Store) set(record model.Record, onlyIfNewer bool) (stored bool, err error) {
+	if this.buffer != nil {
+		return this.bufferedSet(record, onlyIfNewer)
 	}
-	return this.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(BBOLT_BUCKET_NAME).Put([]byte(key), jsonValue)
+	encoded, err := this.encodeValue(record)
+	if err != nil {
+		return false, err
+	}
+	err = this.update(func(tx *bbolt.Tx) error {
+		stored = false
+		if onlyIfNewer {
+			//unreadable values are replaced
+			if device := tx.Bucket(BBOLT_BUCKET_NAME).Bucket([]byte(record.DeviceKey)); device != nil {
+				if existing := device.Get([]byte(record.ServiceKey)); existing != nil {
+					current, err := this.decodeValue(record.DeviceKey, record.ServiceKey, existing)
+					if err == nil && !this.expired(current) && current.OrderTime().After(record.OrderTime()) {
+						return this.appendHistory(tx, record.DeviceKey, record.ServiceKey, record.Time, encoded)
+					}
+				}
+			}
+		}
+		err = this.putValue(tx, record.DeviceKey, record.ServiceKey, encoded)
+		if err != nil {
+			return err
+		}
+		stored = true
+		return this.appendHistory(tx, record.DeviceKey, record.ServiceKey, record.Time, encoded)
 	})
+	return stored, err
 }
 
-func (this *Store) Get(key string) (value []byte, time *time.Time, err error) {
-	err = this.db.View(func(tx *bbolt.Tx) error {
-		temp := tx.Bucket(BBOLT_BUCKET_NAME).Get([]byte(key))
-		if temp == nil {
-			value = []byte("null")
+func (this *Store) putValue(tx *bbolt.Tx, deviceKey string, serviceKey string, encoded []byte) error {
+	this.changed(BBOLT_BUCKET_NAME, []byte(deviceKey))
+	device, err := tx.Bucket(BBOLT_BUCKET_NAME).CreateBucketIfNotExists([]byte(deviceKey))
+	if err != nil {
+		return err
+	}
+	return device.Put([]byte(serviceKey), encoded)
+}
+
+// Touch sets the last seen time of the stored value, if seen is after it; missing and expired values are ignored.
+// the history is not changed.
+func (this *Store) Touch(deviceKey string, serviceKey string, seen time.Time) error {
+	if this.buffer != nil {
+		return this.bufferedTouch(deviceKey, serviceKey, seen)
+	}
+	return this.update(func(tx *bbolt.Tx) error {
+		device := tx.Bucket(BBOLT_BUCKET_NAME).Bucket([]byte(deviceKey))
+		if device == nil {
+			return nil
+		}
+		existing := device.Get([]byte(serviceKey))
+		if existing == nil {
 			return nil
 		}
-		valWithTime := ValueWithTime{}
-		err = json.Unmarshal(temp, &valWithTime)
+		record, err := this.decodeValue(deviceKey, serviceKey, existing)
+		if err != nil || this.expired(record) || !seen.After(record.LastSeen) {
+			return err
+		}
+		record.LastSeen = seen
+		encoded, err := this.encodeValue(record)
 		if err != nil {
-			log.Println("ERROR: unable to unmarshal value from bolt", err)
 			return err
 		}
-		value = valWithTime.Value
-		time = &valWithTime.Time
-		return nil
+		return this.putValue(tx, deviceKey, serviceKey, encoded)
+	})
+}
+
+// Get returns found == false if no value is stored for the device and service or if the value is expired
+func (this *Store) Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error) {
+	if this.buffer != nil {
+		this.buffer.mux.Lock()
+		record, found = this.buffer.get(bufferKey{deviceKey: deviceKey, serviceKey: serviceKey})
+		this.buffer.mux.Unlock()
+		if found && this.expired(record) {
+			return model.Record{}, false, nil
+		}
+		if found {
+			return record, found, nil
+		}
+	}
+	return this.get(deviceKey, serviceKey)
+}
+
+// Expired returns true if the record was last seen more than ttl ago. such values are not read anymore,
+// even if the sweeper did not remove them yet (see Expire); writes of such records are not visible.
+// Import and Migrate skip them, because badger would keep them for ttl after the write.
+func (this *Store) Expired(record model.Record) bool {
+	return this.expired(record)
+}
+
+// expired is Expired; buffered records may have no last seen time yet
+func (this *Store) expired(record model.Record) bool {
+	if this.ttl <= 0 {
+		return false
+	}
+	limit := time.Now().Add(-this.ttl)
+	return record.LastSeen.Before(limit) && record.Received.Before(limit)
+}
+
+func (this *Store) get(deviceKey string, serviceKey string) (record model.Record, found bool, err error) {
+	err = this.view(func(tx *bbolt.Tx) error {
+		var temp []byte
+		if device := tx.Bucket(BBOLT_BUCKET_NAME).Bucket([]byte(deviceKey)); device != nil {
+			temp = device.Get([]byte(serviceKey))
+		}
+		if temp == nil {
+			return nil
+		}
+		record, err = this.decodeValue(deviceKey, serviceKey, temp)
+		found = err == nil && !this.expired(record)
+		return err
 	})
-	return value, time, err
+	return record, found, err
 }
@@ -0,0 +1,1181 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
+	"go.etcd.io/bbolt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompositeKeys(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testRecord("a.b", "c", []byte("1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testRecord("a", "b.c", []byte("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, store, "a.b", "c", "1")
+	checkValue(t, store, "a", "b.c", "2")
+	checkMissing(t, store, "a", "b")
+}
+
+func TestLegacyMigration(t *testing.T) {
+	location := t.TempDir() + "/last_value.db"
+	db, err := bbolt.Open(location, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket(BBOLT_LEGACY_BUCKET_NAME)
+		if err != nil {
+			return err
+		}
+		for key, value := range map[string]string{"d1.s1": "1", "d.2.s2": "2", "invalid": "3"} {
+			temp, err := json.Marshal(codec.ValueWithTime{Value: []byte(value), Time: time.Now()})
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(key), temp)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, location, false, "", "", "", 0, "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, store, "d1", "s1", "1")
+	checkValue(t, store, "d.2", "s2", "2")
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(BBOLT_LEGACY_BUCKET_NAME) != nil {
+			t.Error("legacy bucket not removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	//legacy json records are upgraded in the background
+	for i := 0; ; i++ {
+		upgraded := false
+		err = store.db.View(func(tx *bbolt.Tx) error {
+			value := tx.Bucket(BBOLT_BUCKET_NAME).Bucket([]byte("d1")).Get([]byte("s1"))
+			upgraded = !codec.IsLegacy(value) && string(tx.Bucket(BBOLT_META_BUCKET_NAME).Get(recordFormatKey)) == store.codec.Format()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upgraded {
+			break
+		}
+		if i > 100 {
+			t.Fatal("record not upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkValue(t, store, "d1", "s1", "1")
+}
+
+func TestScan(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"a", "s1"}, {"a", "s2"}, {"a.b", "s1"}, {"b", "s1"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte(`"`+key[0]+"/"+key[1]+`"`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func(deviceKey string) (result []string) {
+		err = store.Scan(deviceKey, func(record model.Record) error {
+			if string(record.Value) != `"`+record.DeviceKey+"/"+record.ServiceKey+`"` || record.Time.IsZero() {
+				t.Error(record)
+			}
+			result = append(result, record.DeviceKey+"/"+record.ServiceKey)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		sort.Strings(result)
+		return result
+	}
+	if result := scan(""); !reflect.DeepEqual(result, []string{"a.b/s1", "a/s1", "a/s2", "b/s1"}) {
+		t.Error(result)
+	}
+	if result := scan("a"); !reflect.DeepEqual(result, []string{"a/s1", "a/s2"}) {
+		t.Error(result)
+	}
+	if result := scan("unknown"); len(result) != 0 {
+		t.Error(result)
+	}
+}
+
+func TestScanSkipsInvalidRecords(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range []string{"s1", "s3"} {
+		err = store.Set(testRecord("d", service, []byte(service)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.update(func(tx *bbolt.Tx) error {
+		return store.putValue(tx, "d", "s2", []byte{codec.Version1, 0xff})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scan := range []func(deviceKey string, handler func(record model.Record) error) error{store.Scan, store.ScanTimes} {
+		result := []string{}
+		err = scan("", func(record model.Record) error {
+			result = append(result, record.ServiceKey)
+			return nil
+		})
+		sort.Strings(result)
+		if err != nil || !reflect.DeepEqual(result, []string{"s1", "s3"}) {
+			t.Error(result, err)
+		}
+	}
+}
+
+func TestScanTimes(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "1h", 0, "zstd", 0, nil, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := testRecord("a", "s1", []byte("1"))
+	old.Time = old.Time.Add(-time.Hour)
+	for _, record := range []model.Record{old, testRecord("b", "s1", []byte("2"))} {
+		err = store.Set(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//buffered update of a committed value and a buffered new value
+	updated := testRecord("a", "s1", []byte("3"))
+	for _, record := range []model.Record{updated, testRecord("a", "s2", []byte("4"))} {
+		err = store.Set(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func(deviceKey string) (result []string) {
+		err = store.ScanTimes(deviceKey, func(record model.Record) error {
+			if record.Value != nil || record.Time.IsZero() {
+				t.Error(record)
+			}
+			if record.DeviceKey == "a" && record.ServiceKey == "s1" && !record.Time.Equal(updated.Time) {
+				t.Error("expected time of buffered value", record)
+			}
+			result = append(result, record.DeviceKey+"/"+record.ServiceKey)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		sort.Strings(result)
+		return result
+	}
+	if result := scan(""); !reflect.DeepEqual(result, []string{"a/s1", "a/s2", "b/s1"}) {
+		t.Error(result)
+	}
+	if result := scan("a"); !reflect.DeepEqual(result, []string{"a/s1", "a/s2"}) {
+		t.Error(result)
+	}
+	_, found, err := store.get("a", "s2")
+	if err != nil || found {
+		t.Error("ScanTimes should not flush the buffer", found, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("length", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 3, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []string{"1", "2", "3", "4", "5"} {
+			err = store.Set(testRecord("d", "s", []byte(value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.Set(testRecord("d", "s2", []byte("6")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "3", "4", "5")
+		checkHistory(t, store, "d", "s", time.Time{}, 2, "4", "5")
+		checkHistory(t, store, "d", "s", time.Now(), 0)
+		checkHistory(t, store, "d", "s2", time.Time{}, 0, "6")
+		checkHistory(t, store, "d", "unknown", time.Time{}, 0)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 0, "1h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.historyMaxAge = 100 * time.Millisecond
+		err = store.Set(testRecord("d", "s", []byte("1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		since := time.Now()
+		time.Sleep(200 * time.Millisecond)
+		checkHistory(t, store, "d", "s", time.Time{}, 0)
+		err = store.Set(testRecord("d", "s", []byte("2")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "2")
+		checkHistory(t, store, "d", "s", since, 0, "2")
+	})
+}
+
+func checkHistory(t *testing.T, store *Store, deviceKey string, serviceKey string, since time.Time, limit int, expected ...string) {
+	t.Helper()
+	history, err := store.History(deviceKey, serviceKey, since, limit)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	actual := []string{}
+	for _, record := range history {
+		actual = append(actual, string(record.Value))
+	}
+	if len(expected) == 0 {
+		expected = []string{}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Error(actual, expected)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := testRecord("d", "s", []byte("1"))
+	err = store.Set(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().Add(time.Minute)
+	err = store.Touch("d", "s", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "s", seen.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "missing", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, found, err := store.Get("d", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(record.Value) != "1" || !record.LastSeen.Equal(seen) || !record.Received.Equal(initial.Received) {
+		t.Error(found, record)
+	}
+	_, found, err = store.Get("d", "missing")
+	if err != nil || found {
+		t.Error(found, err)
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "1")
+}
+
+func TestTouchBuffered(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "1h", 0, "", 0, nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := testRecord("d", "s", []byte("1"))
+	err = store.Set(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().Add(time.Minute)
+	err = store.Touch("d", "s", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "s", seen.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "missing", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, found, err := store.Get("d", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(record.Value) != "1" || !record.LastSeen.Equal(seen) || !record.Received.Equal(initial.Received) {
+		t.Error(found, record)
+	}
+	_, found, err = store.Get("d", "missing")
+	if err != nil || found {
+		t.Error(found, err)
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "1")
+}
+
+func TestSetIfNewerConcurrent(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	writers := sync.WaitGroup{}
+	for _, i := range rand.Perm(50) {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			record := testRecord("d", "s", []byte(strconv.Itoa(i)))
+			record.Time = base.Add(time.Duration(i) * time.Second)
+			_, err := store.SetIfNewer(record)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	writers.Wait()
+	checkValue(t, store, "d", "s", "49")
+
+	record := testRecord("d", "s", []byte("old"))
+	record.Time = base
+	stored, err := store.SetIfNewer(record)
+	if err != nil || stored {
+		t.Error(stored, err)
+	}
+	checkValue(t, store, "d", "s", "49")
+
+	history, err := store.History("d", "s", time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 51 || string(history[0].Value) != "0" || string(history[50].Value) != "49" {
+		t.Error(len(history))
+	}
+}
+
+func TestExpire(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "1h", "1h", "", 0, "", 0, nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := testRecord("old", "s", []byte("1"))
+	old.Time = time.Now().Add(-2 * time.Hour)
+	old.Received = old.Time
+	for _, record := range []model.Record{old, testRecord("new", "s", []byte("2")), testRecord("mixed", "new", []byte("3"))} {
+		err = store.Set(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.DeviceKey = "mixed"
+	err = store.Set(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := store.Expire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 4 { //2 values and 2 history entries
+		t.Error(expired)
+	}
+	checkMissing(t, store, "old", "s")
+	checkMissing(t, store, "mixed", "s")
+	checkValue(t, store, "new", "s", "2")
+	checkValue(t, store, "mixed", "new", "3")
+	checkHistory(t, store, "old", "s", time.Time{}, 0)
+	checkHistory(t, store, "mixed", "new", time.Time{}, 0, "3")
+
+	devices := []string{}
+	err = store.Scan("", func(record model.Record) error {
+		devices = append(devices, record.DeviceKey)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(devices, []string{"mixed", "new"}) {
+		t.Error(devices)
+	}
+
+	expired, err = store.Expire()
+	if err != nil || expired != 0 {
+		t.Error(expired, err)
+	}
+}
+
+func TestExpireOnRead(t *testing.T) {
+	for _, bufferInterval := range []string{"", "1h"} {
+		wg := &sync.WaitGroup{}
+		ctx, cancel := context.WithCancel(context.Background())
+		store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "50ms", "1h", bufferInterval, 0, "", 0, nil, 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Set(testRecord("d", "s", []byte("1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "d", "s", "1")
+		time.Sleep(100 * time.Millisecond)
+
+		//not yet removed by the sweeper, but not readable anymore
+		checkMissing(t, store, "d", "s")
+		checkHistory(t, store, "d", "s", time.Time{}, 0)
+		for _, scan := range []func(deviceKey string, handler func(record model.Record) error) error{store.Scan, store.ScanTimes} {
+			err = scan("", func(record model.Record) error {
+				t.Error("expired record scanned", bufferInterval, record)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		err = store.Touch("d", "s", time.Now())
+		if err != nil {
+			t.Error(err)
+		}
+		checkMissing(t, store, "d", "s")
+
+		older := testRecord("d", "s", []byte("older"))
+		older.Time = older.Time.Add(-time.Hour)
+		stored, err := store.SetIfNewer(older)
+		if err != nil || !stored {
+			t.Error("expired value should be replaced", bufferInterval, stored, err)
+		}
+		checkValue(t, store, "d", "s", "older")
+		cancel()
+		wg.Wait()
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	location := t.TempDir() + "/last_value.db"
+	t.Run("buffered writes", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, false, "", "", "1h", 0, "", 0, nil, 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []string{"1", "2", "3"} {
+			err = store.Set(testRecord("d", "s", []byte(value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		older := testRecord("d", "s", []byte("older"))
+		older.Time = older.Time.Add(-time.Hour)
+		stored, err := store.SetIfNewer(older)
+		if err != nil || stored {
+			t.Error(stored, err)
+		}
+		checkValue(t, store, "d", "s", "3")
+		_, found, err := store.get("d", "s")
+		if err != nil || found {
+			t.Error("value should not be committed yet", found, err)
+		}
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "older", "1", "2", "3")
+		_, found, err = store.get("d", "s")
+		if err != nil || !found {
+			t.Error("value should be committed by range operation", found, err)
+		}
+		err = store.Set(testRecord("d", "s", []byte("4")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("flushed on shutdown", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, false, "", "", "", 0, "", 0, nil, 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "d", "s", "4")
+	})
+	t.Run("flushed by size", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "1h", 2, "", 0, nil, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, service := range []string{"s1", "s2"} {
+			err = store.Set(testRecord("d", service, []byte(service)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 100; i++ {
+			if _, found, _ := store.get("d", "s2"); found {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("buffer not flushed after reaching its size")
+	})
+}
+
+func TestCompression(t *testing.T) {
+	location := t.TempDir() + "/last_value.db"
+	large := `{"state":"` + strings.Repeat("on,", 1000) + `"}`
+	t.Run("compressed", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, false, "", "", "", 0, "zstd", 100, nil, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Set(testRecord("d", "large", []byte(large)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats := store.codec.Stats(); stats.CompressedRecords != 1 || stats.SavedBytes <= 0 {
+			t.Error(stats)
+		}
+	})
+	t.Run("mixed", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, false, "", "", "", 0, "", 0, nil, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Set(testRecord("d", "plain", []byte(large)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "d", "large", large)
+		checkValue(t, store, "d", "plain", large)
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	location := t.TempDir() + "/last_value.db"
+	key := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	open := func(t *testing.T, ctx context.Context, wg *sync.WaitGroup, key []byte) (*Store, error) {
+		return New(ctx, wg, location, false, "", "", "", 0, "", 0, key, 10, "")
+	}
+	t.Run("unencrypted", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := open(t, ctx, wg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Set(testRecord("d", "s", []byte("secret 1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("encrypt existing", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := open(t, ctx, wg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-store.upgradeDone
+		err = store.Set(testRecord("d", "s2", []byte("secret 2")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "d", "s", "secret 1")
+		checkHistory(t, store, "d", "s", time.Time{}, 0, "secret 1")
+		err = store.db.View(func(tx *bbolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+				return b.ForEachBucket(func(k []byte) error {
+					return b.Bucket(k).ForEach(func(k, v []byte) error {
+						if bytes.Contains(v, []byte("secret")) {
+							t.Error("unencrypted value in", string(name), string(k))
+						}
+						return nil
+					})
+				})
+			})
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("missing or wrong key", func(t *testing.T) {
+		for _, k := range [][]byte{nil, newKey} {
+			wg := &sync.WaitGroup{}
+			ctx, cancel := context.WithCancel(context.Background())
+			_, err := open(t, ctx, wg, k)
+			cancel()
+			wg.Wait()
+			if err == nil {
+				t.Error("expected error")
+			}
+		}
+	})
+	t.Run("rotate", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := open(t, ctx, wg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := store.RotateKey(newKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 4 { //2 values and 2 history entries
+			t.Error(count)
+		}
+	})
+	t.Run("new key", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := open(t, ctx, wg, newKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "d", "s", "secret 1")
+		checkValue(t, store, "d", "s2", "secret 2")
+		checkHistory(t, store, "d", "s2", time.Time{}, 0, "secret 2")
+	})
+}
+
+func TestCompact(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("x"), 10000)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			err = store.Set(testRecord("d", "s"+strconv.Itoa(j), append(large, strconv.Itoa(i)...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	before, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Values != 10 || before.HistoryEntries != 20 || before.Bolt.FreePages == 0 || before.DiskSize == 0 {
+		t.Errorf("%#v %#v", before, before.Bolt)
+	}
+
+	//writes during the compaction are copied before the file is replaced
+	writes := make(chan error)
+	go func() {
+		for i := 0; i < 10; i++ {
+			err := store.Set(testRecord("concurrent", strconv.Itoa(i), []byte("1")))
+			if err != nil {
+				writes <- err
+				return
+			}
+		}
+		writes <- nil
+	}()
+	result, err := store.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Operation != "compaction" || result.SizeAfter > result.SizeBefore {
+		t.Error(result)
+	}
+	err = <-writes
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Values != 20 || after.HistoryEntries != 30 || after.Bolt.LastCompaction == nil || after.Bolt.FreePages >= before.Bolt.FreePages {
+		t.Errorf("%#v %#v", after, after.Bolt)
+	}
+	checkValue(t, store, "d", "s9", string(large)+"19")
+	checkHistory(t, store, "d", "s0", time.Time{}, 0, string(large)+"18", string(large)+"19")
+	checkValue(t, store, "concurrent", "9", "1")
+}
+
+func TestCompactOnline(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", "", 0, "", 0, nil, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//enough devices for several copy transactions
+	large := bytes.Repeat([]byte("x"), 10000)
+	for i := 0; i < 100; i++ {
+		err = store.Set(testRecord("d"+strconv.Itoa(i), "s", large))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//reads and writes continue during the compaction
+	done := make(chan struct{})
+	type result struct {
+		writes int
+		err    error
+	}
+	results := make(chan result)
+	go func() {
+		writes := 0
+		for {
+			select {
+			case <-done:
+				results <- result{writes: writes}
+				return
+			default:
+			}
+			device := "d" + strconv.Itoa(writes%100)
+			_, err := store.DeletePrefix(device)
+			if err == nil {
+				err = store.Set(testRecord(device, "s", []byte(strconv.Itoa(writes))))
+			}
+			if err == nil {
+				err = store.Set(testRecord("new"+strconv.Itoa(writes), "s", []byte("1")))
+			}
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			writes++
+		}
+	}()
+	_, err = store.Compact()
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concurrent := <-results
+	if concurrent.err != nil {
+		t.Fatal(concurrent.err)
+	}
+	if concurrent.writes == 0 {
+		t.Fatal("no writes during the compaction")
+	}
+	for i := 0; i < concurrent.writes; i++ {
+		checkValue(t, store, "new"+strconv.Itoa(i), "s", "1")
+	}
+	for i := 0; i < 100; i++ {
+		expected := string(large)
+		for j := i; j < concurrent.writes; j += 100 {
+			expected = strconv.Itoa(j)
+		}
+		checkValue(t, store, "d"+strconv.Itoa(i), "s", expected)
+		checkHistory(t, store, "d"+strconv.Itoa(i), "s", time.Time{}, 0, expected)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Values != 100+concurrent.writes {
+		t.Error(stats.Values, concurrent.writes)
+	}
+}
+
+func TestCompactFailure(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	location := t.TempDir() + "/last_value.db"
+	store, err := New(ctx, wg, location, false, "", "", "", 0, "", 0, nil, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testRecord("d", "s", []byte("1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the temporary file can not be created
+	err = os.MkdirAll(location+".compact/blocked", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := store.Compact()
+	if err == nil || result.Error == "" {
+		t.Error("expected error", result)
+	}
+	checkValue(t, store, "d", "s", "1")
+	err = store.Set(testRecord("d", "s", []byte("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, store, "d", "s", "2")
+}
+
+func TestCorruption(t *testing.T) {
+	open := func(location string, verify bool) error {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err := New(ctx, wg, location, verify, "", "", "", 0, "", 0, nil, 10, "")
+		return err
+	}
+	t.Run("invalid file", func(t *testing.T) {
+		location := t.TempDir() + "/last_value.db"
+		err := os.WriteFile(location, bytes.Repeat([]byte("garbage"), 1000), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = open(location, false)
+		if !errors.Is(err, storage.ErrCorrupt) {
+			t.Error(err)
+		}
+	})
+	t.Run("directory", func(t *testing.T) {
+		err := open(t.TempDir(), false)
+		if err == nil || errors.Is(err, storage.ErrCorrupt) {
+			t.Error("directory is no corruption", err)
+		}
+	})
+	t.Run("damaged page", func(t *testing.T) {
+		location := t.TempDir() + "/last_value.db"
+		func() {
+			wg := &sync.WaitGroup{}
+			defer wg.Wait()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			store, err := New(ctx, wg, location, false, "", "", "", 0, "", 0, nil, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				err = store.Set(testRecord("d"+strconv.Itoa(i), "s", bytes.Repeat([]byte("v"), 100)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}()
+		err := open(location, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//damage the data pages, but keep the meta and freelist pages, so that only the verification notices
+		damaged := []int{}
+		db, err := bbolt.Open(location, 0666, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.View(func(tx *bbolt.Tx) error {
+			for id := 0; ; id++ {
+				page, err := tx.Page(id)
+				if err != nil || page == nil {
+					return err
+				}
+				if page.Type == "leaf" || page.Type == "branch" {
+					damaged = append(damaged, id)
+				}
+			}
+		})
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageSize := os.Getpagesize()
+		for _, id := range damaged {
+			for i := id * pageSize; i < (id+1)*pageSize; i++ {
+				content[i] = 0xff
+			}
+		}
+		err = os.WriteFile(location, content, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = open(location, true)
+		if !errors.Is(err, storage.ErrCorrupt) {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	for name, bufferInterval := range map[string]string{"unbuffered": "", "buffered": "1h"} {
+		t.Run(name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			defer wg.Wait()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", bufferInterval, 0, "", 0, nil, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range [][2]string{{"d", "s"}, {"d", "s2"}, {"d", "s3"}, {"d2", "s"}} {
+				err = store.Set(testRecord(key[0], key[1], []byte(key[0]+key[1])))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = store.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			//buffered value of a committed service and of a new service
+			err = store.Set(testRecord("d", "s3", []byte("ds3.2")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.Set(testRecord("d", "s4", []byte("ds4")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deleted, err := store.Delete("d", "s")
+			if err != nil || !deleted {
+				t.Error(deleted, err)
+			}
+			deleted, err = store.Delete("d", "s")
+			if err != nil || deleted {
+				t.Error(deleted, err)
+			}
+			checkMissing(t, store, "d", "s")
+			checkHistory(t, store, "d", "s", time.Time{}, 0)
+			checkValue(t, store, "d", "s2", "ds2")
+
+			count, err := store.DeletePrefix("d")
+			if err != nil || count != 3 {
+				t.Error(count, err)
+			}
+			err = store.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, service := range []string{"s2", "s3", "s4"} {
+				checkMissing(t, store, "d", service)
+				checkHistory(t, store, "d", service, time.Time{}, 0)
+			}
+			checkValue(t, store, "d2", "s", "d2s")
+			checkHistory(t, store, "d2", "s", time.Time{}, 0, "d2s")
+
+			count, err = store.DeletePrefix("d")
+			if err != nil || count != 0 {
+				t.Error(count, err)
+			}
+			err = store.view(func(tx *bbolt.Tx) error {
+				if tx.Bucket(BBOLT_BUCKET_NAME).Bucket([]byte("d")) != nil || tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).Bucket([]byte("d")) != nil {
+					t.Error("device buckets should be removed")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSetIfNewerFutureTime(t *testing.T) {
+	for _, bufferInterval := range []string{"", "1h"} {
+		wg := &sync.WaitGroup{}
+		ctx, cancel := context.WithCancel(context.Background())
+		store, err := New(ctx, wg, t.TempDir()+"/last_value.db", false, "", "", bufferInterval, 0, "", 0, nil, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//a wrong future time must not block later values
+		future := testRecord("d", "s", []byte("future"))
+		future.Received = future.Received.Add(-time.Hour)
+		future.Time = future.Received.Add(24 * time.Hour)
+		tolerated := testRecord("d", "s", []byte("tolerated"))
+		tolerated.Time = tolerated.Received.Add(model.MaxFutureTime / 2)
+		older := testRecord("d", "s", []byte("older"))
+		older.Time = older.Time.Add(-time.Minute)
+		for _, step := range []struct {
+			record model.Record
+			stored bool
+		}{{future, true}, {testRecord("d", "s", []byte("now")), true}, {tolerated, true}, {older, false}} {
+			stored, err := store.SetIfNewer(step.record)
+			if err != nil || stored != step.stored {
+				t.Error(string(step.record.Value), stored, err)
+			}
+		}
+		checkValue(t, store, "d", "s", "tolerated")
+		cancel()
+		wg.Wait()
+	}
+}
+
+func checkValue(t *testing.T, store *Store, deviceKey string, serviceKey string, expected string) {
+	t.Helper()
+	record, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !found || string(record.Value) != expected || record.Time.IsZero() {
+		t.Error(deviceKey, serviceKey, found, record, expected)
+	}
+}
+
+func checkMissing(t *testing.T, store *Store, deviceKey string, serviceKey string) {
+	t.Helper()
+	_, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found {
+		t.Error("unexpected value for", deviceKey, serviceKey)
+	}
+}
+
+func testRecord(deviceKey string, serviceKey string, value []byte) model.Record {
+	now := time.Now()
+	return model.Record{DeviceKey: deviceKey, ServiceKey: serviceKey, Value: value, Time: now, Received: now}
+}
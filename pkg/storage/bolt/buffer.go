@@ -0,0 +1,208 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+	"log"
+	"sync"
+	"time"
+)
+
+type bufferKey struct {
+	deviceKey  string
+	serviceKey string
+}
+
+// writeBuffer collects writes and commits them in a single transaction.
+// values are coalesced per key; history entries are kept in arrival order.
+// values of a running flush stay readable in flushing until they are committed.
+type writeBuffer struct {
+	mux         sync.Mutex
+	values      map[bufferKey]model.Record
+	history     []model.Record
+	writes      int
+	flushing    map[bufferKey]model.Record
+	flushMux    sync.Mutex
+	size        int
+	flushSignal chan struct{}
+}
+
+func newWriteBuffer(size int) *writeBuffer {
+	return &writeBuffer{
+		values:      map[bufferKey]model.Record{},
+		flushing:    map[bufferKey]model.Record{},
+		size:        size,
+		flushSignal: make(chan struct{}, 1),
+	}
+}
+
+// get returns the newest buffered value; this.buffer.mux has to be locked by the caller
+func (this *writeBuffer) get(k bufferKey) (record model.Record, found bool) {
+	record, found = this.values[k]
+	if !found {
+		record, found = this.flushing[k]
+	}
+	return record, found
+}
+
+// startWriteBuffer flushes the buffer every interval and whenever it reaches its size.
+// the final flush on shutdown is done before the db is closed.
+func (this *Store) startWriteBuffer(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-this.buffer.flushSignal:
+			}
+			err := this.Flush()
+			if err != nil {
+				log.Println("ERROR: unable to flush bolt write buffer:", err)
+			}
+		}
+	}()
+}
+
+func (this *Store) bufferedSet(record model.Record, onlyIfNewer bool) (stored bool, err error) {
+	this.buffer.mux.Lock()
+	defer this.buffer.mux.Unlock()
+	k := bufferKey{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}
+	if onlyIfNewer {
+		current, found := this.buffer.get(k)
+		if !found {
+			//unreadable values are replaced
+			current, found, _ = this.get(record.DeviceKey, record.ServiceKey)
+		}
+		stored = !found || this.expired(current) || !current.OrderTime().After(record.OrderTime())
+	} else {
+		stored = true
+	}
+	if stored {
+		this.buffer.values[k] = record
+	}
+	if this.historyEnabled() {
+		this.buffer.history = append(this.buffer.history, record)
+	}
+	this.buffer.writes++
+	if this.buffer.size > 0 && this.buffer.writes >= this.buffer.size {
+		select {
+		case this.buffer.flushSignal <- struct{}{}:
+		default:
+		}
+	}
+	return stored, nil
+}
+
+func (this *Store) bufferedTouch(deviceKey string, serviceKey string, seen time.Time) error {
+	this.buffer.mux.Lock()
+	defer this.buffer.mux.Unlock()
+	k := bufferKey{deviceKey: deviceKey, serviceKey: serviceKey}
+	record, found := this.buffer.get(k)
+	if !found {
+		var err error
+		record, found, err = this.get(deviceKey, serviceKey)
+		if err != nil {
+			return err
+		}
+	}
+	if !found || this.expired(record) || !seen.After(record.LastSeen) {
+		return nil
+	}
+	record.LastSeen = seen
+	this.buffer.values[k] = record
+	this.buffer.writes++
+	if this.buffer.size > 0 && this.buffer.writes >= this.buffer.size {
+		select {
+		case this.buffer.flushSignal <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush commits all buffered writes in a single transaction; without write buffer, Flush does nothing.
+// if the transaction fails, the writes are kept in the buffer for the next flush.
+func (this *Store) Flush() error {
+	if this.buffer == nil {
+		return nil
+	}
+	this.buffer.flushMux.Lock()
+	defer this.buffer.flushMux.Unlock()
+
+	this.buffer.mux.Lock()
+	values := this.buffer.values
+	history := this.buffer.history
+	this.buffer.flushing = values
+	this.buffer.values = map[bufferKey]model.Record{}
+	this.buffer.history = nil
+	this.buffer.writes = 0
+	this.buffer.mux.Unlock()
+
+	if len(values) == 0 && len(history) == 0 {
+		return nil
+	}
+
+	err := this.update(func(tx *bbolt.Tx) error {
+		for _, record := range values {
+			encoded, err := this.encodeValue(record)
+			if err != nil {
+				return err
+			}
+			err = this.putValue(tx, record.DeviceKey, record.ServiceKey, encoded)
+			if err != nil {
+				return err
+			}
+		}
+		for _, record := range history {
+			encoded, err := this.encodeValue(record)
+			if err != nil {
+				return err
+			}
+			err = this.appendHistory(tx, record.DeviceKey, record.ServiceKey, record.Time, encoded)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	this.buffer.mux.Lock()
+	defer this.buffer.mux.Unlock()
+	if err != nil {
+		for k, record := range values {
+			if _, newer := this.buffer.values[k]; !newer {
+				this.buffer.values[k] = record
+			}
+		}
+		this.buffer.history = append(history, this.buffer.history...)
+		this.buffer.writes += len(values)
+	}
+	this.buffer.flushing = map[bufferKey]model.Record{}
+	return err
+}
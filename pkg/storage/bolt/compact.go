@@ -0,0 +1,321 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+	"log"
+	"os"
+	"time"
+)
+
+// compactTxMaxSize is the size in bytes after which Compact commits the copy and starts a new read transaction
+const compactTxMaxSize = 64 << 10
+
+// Compact copies the database into a fresh file and replaces the current file with it, which returns the free pages to the file system.
+// the compaction is online: the copy is made in short read transactions while reads and writes continue.
+// writes during the copy are recorded by device (see changed); only the copy of these devices and the replacement
+// of the file block reads and writes. the result is reported by Stats.
+func (this *Store) Compact() (result model.MaintenanceResult, err error) {
+	this.compactMux.Lock()
+	defer this.compactMux.Unlock()
+	err = this.Flush()
+	if err != nil {
+		return result, err
+	}
+	result.Operation = "compaction"
+	result.Start = time.Now()
+	result.SizeBefore = fileSize(this.location)
+	err = this.compact()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.SizeAfter = fileSize(this.location)
+	result.Duration = time.Since(result.Start).String()
+	this.dbMux.Lock()
+	this.lastCompaction = &result
+	this.dbMux.Unlock()
+	return result, err
+}
+
+// compact copies the database into a temporary file, which replaces the current file while both are open.
+// the current handle is only closed after the replacement succeeded, so db stays usable if the compaction fails.
+func (this *Store) compact() error {
+	temp := this.location + ".compact"
+	err := os.Remove(temp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	//the copy is synced once before it replaces the current file
+	target, err := bbolt.Open(temp, 0666, &bbolt.Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		target.Close()
+		os.Remove(temp)
+		return err
+	}
+
+	//no write transaction may run while the recording starts, otherwise its changes could be missing in the copy
+	this.dbMux.Lock()
+	this.changesMux.Lock()
+	this.changes = map[string]map[string]bool{}
+	this.changesMux.Unlock()
+	this.dbMux.Unlock()
+	err = this.copyDatabase(target)
+	if err != nil {
+		this.changesMux.Lock()
+		this.changes = nil
+		this.changesMux.Unlock()
+		return fail(errors.New("unable to copy bolt database:" + err.Error()))
+	}
+
+	this.dbMux.Lock()
+	defer this.dbMux.Unlock()
+	this.changesMux.Lock()
+	changes := this.changes
+	this.changes = nil
+	this.changesMux.Unlock()
+	err = copyChanges(target, this.db, changes)
+	if err != nil {
+		return fail(errors.New("unable to copy changes during bolt compaction:" + err.Error()))
+	}
+	err = target.Sync()
+	if err != nil {
+		return fail(err)
+	}
+	target.NoSync = false
+	err = os.Rename(temp, this.location)
+	if err != nil {
+		return fail(errors.New("unable to replace bolt file:" + err.Error()))
+	}
+	err = this.db.Close()
+	if err != nil {
+		log.Println("WARNING: unable to close replaced bolt file:", err)
+	}
+	this.db = target
+	return nil
+}
+
+// changed records a write to key (nil: the whole bucket) of the root bucket while Compact copies the database,
+// so that it is copied again before the copy replaces the current file. has to be called in the write transaction.
+func (this *Store) changed(root []byte, key []byte) {
+	this.changesMux.Lock()
+	defer this.changesMux.Unlock()
+	if this.changes == nil {
+		return
+	}
+	keys, ok := this.changes[string(root)]
+	if !ok {
+		keys = map[string]bool{}
+		this.changes[string(root)] = keys
+	}
+	keys[string(key)] = true
+}
+
+// copyDatabase copies all root buckets into target; the nested buckets of a root (e.g. devices) are copied
+// in read transactions of about compactTxMaxSize bytes, so long copies do not block the growth of the file by writes
+func (this *Store) copyDatabase(target *bbolt.DB) error {
+	roots := [][]byte{}
+	err := this.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			roots = append(roots, append([]byte{}, name...))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		var next []byte
+		//the first transaction of the root copies its sequence and values, the nested buckets are copied from next on
+		first := true
+		for first || next != nil {
+			err = this.view(func(tx *bbolt.Tx) error {
+				source := tx.Bucket(root)
+				if source == nil {
+					next = nil
+					return nil //removed during the copy, which is recorded as change
+				}
+				return target.Update(func(targetTx *bbolt.Tx) error {
+					bucket, err := targetTx.CreateBucketIfNotExists(root)
+					if err != nil {
+						return err
+					}
+					if first {
+						err = copyValues(bucket, source)
+						if err != nil {
+							return err
+						}
+					}
+					next, err = copyNestedBuckets(bucket, source, next)
+					return err
+				})
+			})
+			if err != nil {
+				return err
+			}
+			first = false
+		}
+	}
+	return nil
+}
+
+// copyValues copies the sequence and the values, but not the nested buckets of source into target
+func copyValues(target *bbolt.Bucket, source *bbolt.Bucket) error {
+	err := target.SetSequence(source.Sequence())
+	if err != nil {
+		return err
+	}
+	return source.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		return target.Put(k, v)
+	})
+}
+
+// copyNestedBuckets copies the nested buckets of source starting at from into target,
+// until about compactTxMaxSize bytes are copied; next is the name of the first bucket not copied yet (nil if all are copied)
+func copyNestedBuckets(target *bbolt.Bucket, source *bbolt.Bucket, from []byte) (next []byte, err error) {
+	size := 0
+	c := source.Cursor()
+	k, v := c.First()
+	if from != nil {
+		k, v = c.Seek(from)
+	}
+	for ; k != nil; k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+		if size >= compactTxMaxSize {
+			return append([]byte{}, k...), nil
+		}
+		nested, err := target.CreateBucket(k)
+		if err != nil {
+			return nil, err
+		}
+		copied, err := copyBucket(nested, source.Bucket(k))
+		if err != nil {
+			return nil, err
+		}
+		size += copied
+	}
+	return nil, nil
+}
+
+// copyBucket copies source including its nested buckets into the empty target and returns the copied size in bytes
+func copyBucket(target *bbolt.Bucket, source *bbolt.Bucket) (size int, err error) {
+	err = target.SetSequence(source.Sequence())
+	if err != nil {
+		return 0, err
+	}
+	err = source.ForEach(func(k, v []byte) error {
+		size += len(k) + len(v)
+		if v != nil {
+			return target.Put(k, v)
+		}
+		nested, err := target.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		copied, err := copyBucket(nested, source.Bucket(k))
+		size += copied
+		return err
+	})
+	return size, err
+}
+
+// copyChanges replaces the changed keys (or whole root buckets) of target with their current state in source
+func copyChanges(target *bbolt.DB, source *bbolt.DB, changes map[string]map[string]bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return source.View(func(tx *bbolt.Tx) error {
+		return target.Update(func(targetTx *bbolt.Tx) error {
+			for root, keys := range changes {
+				sourceRoot := tx.Bucket([]byte(root))
+				if keys[""] {
+					err := targetTx.DeleteBucket([]byte(root))
+					if err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+						return err
+					}
+					if sourceRoot == nil {
+						continue
+					}
+					bucket, err := targetTx.CreateBucket([]byte(root))
+					if err != nil {
+						return err
+					}
+					_, err = copyBucket(bucket, sourceRoot)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				if sourceRoot == nil {
+					continue //the whole root was removed, which is recorded as change of the root
+				}
+				targetRoot, err := targetTx.CreateBucketIfNotExists([]byte(root))
+				if err != nil {
+					return err
+				}
+				err = targetRoot.SetSequence(sourceRoot.Sequence())
+				if err != nil {
+					return err
+				}
+				for key := range keys {
+					err = copyKey(targetRoot, sourceRoot, []byte(key))
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// copyKey replaces the value or nested bucket key of target with the one of source; it is removed if source has none
+func copyKey(target *bbolt.Bucket, source *bbolt.Bucket, key []byte) error {
+	if target.Bucket(key) != nil {
+		err := target.DeleteBucket(key)
+		if err != nil {
+			return err
+		}
+	} else if target.Get(key) != nil {
+		err := target.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	if nested := source.Bucket(key); nested != nil {
+		bucket, err := target.CreateBucket(key)
+		if err != nil {
+			return err
+		}
+		_, err = copyBucket(bucket, nested)
+		return err
+	}
+	if value := source.Get(key); value != nil {
+		return target.Put(key, value)
+	}
+	return nil
+}
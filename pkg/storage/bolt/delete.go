@@ -0,0 +1,131 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+)
+
+// Delete removes the value of the device service and its history; deleted is false if no value was stored
+func (this *Store) Delete(deviceKey string, serviceKey string) (deleted bool, err error) {
+	count, err := this.delete(deviceKey, func(k bufferKey) bool {
+		return k.deviceKey == deviceKey && k.serviceKey == serviceKey
+	}, [][]byte{[]byte(serviceKey)})
+	return count > 0, err
+}
+
+// DeletePrefix removes all values of the device and their history; deleted is the number of removed values
+func (this *Store) DeletePrefix(deviceKey string) (deleted int, err error) {
+	return this.delete(deviceKey, func(k bufferKey) bool {
+		return k.deviceKey == deviceKey
+	}, nil)
+}
+
+// delete removes the services of the device (all services if services is nil) from the buffer and the database.
+// a concurrent flush could commit buffered values after they are deleted, so flushes wait for delete.
+func (this *Store) delete(deviceKey string, match func(k bufferKey) bool, services [][]byte) (deleted int, err error) {
+	buffered := map[bufferKey]bool{}
+	if this.buffer != nil {
+		this.buffer.flushMux.Lock()
+		defer this.buffer.flushMux.Unlock()
+		buffered = this.buffer.drop(match)
+	}
+	err = this.update(func(tx *bbolt.Tx) error {
+		deleted = 0
+		this.changed(BBOLT_BUCKET_NAME, []byte(deviceKey))
+		this.changed(BBOLT_HISTORY_BUCKET_NAME, []byte(deviceKey))
+		values := tx.Bucket(BBOLT_BUCKET_NAME)
+		if device := values.Bucket([]byte(deviceKey)); device != nil {
+			keys := services
+			if keys == nil {
+				keys = bucketKeys(device)
+			}
+			for _, serviceKey := range keys {
+				if device.Get(serviceKey) == nil {
+					continue
+				}
+				err := device.Delete(serviceKey)
+				if err != nil {
+					return err
+				}
+				if !buffered[bufferKey{deviceKey: deviceKey, serviceKey: string(serviceKey)}] {
+					deleted++
+				}
+			}
+			err := deleteIfEmpty(values, []byte(deviceKey))
+			if err != nil {
+				return err
+			}
+		}
+		history := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME)
+		device := history.Bucket([]byte(deviceKey))
+		if device == nil {
+			return nil
+		}
+		if services == nil {
+			return history.DeleteBucket([]byte(deviceKey))
+		}
+		for _, serviceKey := range services {
+			if device.Bucket(serviceKey) == nil {
+				continue
+			}
+			err := device.DeleteBucket(serviceKey)
+			if err != nil {
+				return err
+			}
+		}
+		return deleteIfEmpty(history, []byte(deviceKey))
+	})
+	return deleted + len(buffered), err
+}
+
+func bucketKeys(bucket *bbolt.Bucket) (result [][]byte) {
+	bucket.ForEach(func(k, v []byte) error {
+		result = append(result, append([]byte{}, k...))
+		return nil
+	})
+	return result
+}
+
+func deleteIfEmpty(parent *bbolt.Bucket, name []byte) error {
+	if k, _ := parent.Bucket(name).Cursor().First(); k != nil {
+		return nil
+	}
+	return parent.DeleteBucket(name)
+}
+
+// drop removes the buffered values and history entries matching match and returns the keys of the removed values
+func (this *writeBuffer) drop(match func(k bufferKey) bool) (dropped map[bufferKey]bool) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	dropped = map[bufferKey]bool{}
+	for k := range this.values {
+		if match(k) {
+			delete(this.values, k)
+			dropped[k] = true
+		}
+	}
+	history := []model.Record{}
+	for _, record := range this.history {
+		if !match(bufferKey{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}) {
+			history = append(history, record)
+		}
+	}
+	this.history = history
+	return dropped
+}
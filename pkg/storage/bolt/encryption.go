@@ -0,0 +1,149 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/codec"
+	"go.etcd.io/bbolt"
+	"log"
+)
+
+// encryptionCheckKey stores an encrypted record in BBOLT_META_BUCKET_NAME to detect wrong or missing keys on start
+var encryptionCheckKey = []byte("encryption_check")
+
+func encryptionCheckRecord() model.Record {
+	return model.Record{ServiceKey: string(encryptionCheckKey), Value: []byte("ok")}
+}
+
+// checkEncryptionKey fails if the database is encrypted with another key or if it is encrypted and no key is configured.
+// unencrypted databases are encrypted by the record upgrade, if a key is configured.
+func (this *Store) checkEncryptionKey() error {
+	return this.update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(BBOLT_META_BUCKET_NAME)
+		if err != nil {
+			return err
+		}
+		check := meta.Get(encryptionCheckKey)
+		if check != nil {
+			if !this.codec.Encrypted() {
+				return errors.New("bolt database is encrypted, but no encryption key is configured")
+			}
+			_, err = this.codec.Decode("", string(encryptionCheckKey), check)
+			if err != nil {
+				return errors.New("bolt encryption key does not match the database; use the rotate-key command to change the key: " + err.Error())
+			}
+			return nil
+		}
+		if !this.codec.Encrypted() {
+			return nil
+		}
+		log.Println("enable bolt encryption; existing records are encrypted in the background")
+		return this.putEncryptionCheck(meta, this.codec)
+	})
+}
+
+func (this *Store) putEncryptionCheck(meta *bbolt.Bucket, recordCodec *codec.Codec) error {
+	if !recordCodec.Encrypted() {
+		return meta.Delete(encryptionCheckKey)
+	}
+	check, err := recordCodec.Encode(encryptionCheckRecord())
+	if err != nil {
+		return err
+	}
+	return meta.Put(encryptionCheckKey, check)
+}
+
+// RotateKey re-encrypts all records with newKey in a single transaction; with a nil newKey, the records are decrypted.
+// the service must not use the database during the rotation; a running record upgrade is finished first.
+func (this *Store) RotateKey(newKey []byte) (count int, err error) {
+	<-this.upgradeDone
+	newCodec, err := this.codec.WithKey(newKey)
+	if err != nil {
+		return 0, err
+	}
+	err = this.Flush()
+	if err != nil {
+		return 0, err
+	}
+	err = this.update(func(tx *bbolt.Tx) error {
+		count = 0
+		this.changed(BBOLT_BUCKET_NAME, nil)
+		this.changed(BBOLT_HISTORY_BUCKET_NAME, nil)
+		this.changed(BBOLT_META_BUCKET_NAME, nil)
+		reencode := func(deviceKey string, bucket *bbolt.Bucket, serviceKey func(k []byte) string) error {
+			values := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				if v != nil {
+					values[string(k)] = v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range values {
+				record, err := this.decodeValue(deviceKey, serviceKey([]byte(k)), v)
+				if err != nil {
+					return err
+				}
+				encoded, err := newCodec.Encode(record)
+				if err != nil {
+					return err
+				}
+				err = bucket.Put([]byte(k), encoded)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}
+		values := tx.Bucket(BBOLT_BUCKET_NAME)
+		err := values.ForEachBucket(func(deviceKey []byte) error {
+			return reencode(string(deviceKey), values.Bucket(deviceKey), func(k []byte) string { return string(k) })
+		})
+		if err != nil {
+			return err
+		}
+		history := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME)
+		err = history.ForEachBucket(func(deviceKey []byte) error {
+			device := history.Bucket(deviceKey)
+			return device.ForEachBucket(func(serviceKey []byte) error {
+				return reencode(string(deviceKey), device.Bucket(serviceKey), func([]byte) string { return string(serviceKey) })
+			})
+		})
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(BBOLT_META_BUCKET_NAME)
+		if err != nil {
+			return err
+		}
+		err = this.putEncryptionCheck(meta, newCodec)
+		if err != nil {
+			return err
+		}
+		return meta.Put(recordFormatKey, []byte(newCodec.Format()))
+	})
+	if err != nil {
+		return 0, err
+	}
+	this.codec = newCodec
+	return count, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// BBOLT_HISTORY_BUCKET_NAME contains one nested bucket per device, which contains one nested bucket per service,
+// which contains the history entries keyed by historyKey
+var BBOLT_HISTORY_BUCKET_NAME = []byte("history")
+
+func (this *Store) historyEnabled() bool {
+	return this.historyLength > 0 || this.historyMaxAge > 0
+}
+
+// historyKey orders entries by time; seq distinguishes entries with the same time.
+// times before 1970 are mapped to 1970.
+func historyKey(t time.Time, seq uint64) []byte {
+	nanos := uint64(0)
+	if t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+	result := binary.BigEndian.AppendUint64(make([]byte, 0, 16), nanos)
+	return binary.BigEndian.AppendUint64(result, seq)
+}
+
+func (this *Store) appendHistory(tx *bbolt.Tx, deviceKey string, serviceKey string, t time.Time, encoded []byte) error {
+	if !this.historyEnabled() {
+		return nil
+	}
+	this.changed(BBOLT_HISTORY_BUCKET_NAME, []byte(deviceKey))
+	device, err := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).CreateBucketIfNotExists([]byte(deviceKey))
+	if err != nil {
+		return err
+	}
+	service, err := device.CreateBucketIfNotExists([]byte(serviceKey))
+	if err != nil {
+		return err
+	}
+	seq, err := service.NextSequence()
+	if err != nil {
+		return err
+	}
+	err = service.Put(historyKey(t, seq), encoded)
+	if err != nil {
+		return err
+	}
+	return this.trimHistory(service)
+}
+
+// trimHistory removes entries older than historyMaxAge and the oldest entries exceeding historyLength
+func (this *Store) trimHistory(service *bbolt.Bucket) error {
+	remove := [][]byte{}
+	c := service.Cursor()
+	if this.historyMaxAge > 0 {
+		limit := historyKey(time.Now().Add(-this.historyMaxAge), 0)
+		for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.Next() {
+			remove = append(remove, append([]byte{}, k...))
+		}
+	}
+	if this.historyLength > 0 {
+		count := 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			count++
+			if count > this.historyLength {
+				remove = append(remove, append([]byte{}, k...))
+			}
+		}
+	}
+	for _, k := range remove {
+		err := service.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the stored history entries of a device and service since the given time (and within historyMaxAge and ttl) in chronological order.
+// if limit > 0, only the newest limit entries are returned. buffered writes are flushed first.
+func (this *Store) History(deviceKey string, serviceKey string, since time.Time, limit int) (result []model.Record, err error) {
+	//entries older than historyMaxAge are only removed by the next write to the key
+	if this.historyMaxAge > 0 && since.Before(time.Now().Add(-this.historyMaxAge)) {
+		since = time.Now().Add(-this.historyMaxAge)
+	}
+	//expired entries are only removed by the next sweep
+	if this.ttl > 0 && since.Before(time.Now().Add(-this.ttl)) {
+		since = time.Now().Add(-this.ttl)
+	}
+	err = this.Flush()
+	if err != nil {
+		return result, err
+	}
+	result = []model.Record{}
+	err = this.view(func(tx *bbolt.Tx) error {
+		device := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).Bucket([]byte(deviceKey))
+		if device == nil {
+			return nil
+		}
+		service := device.Bucket([]byte(serviceKey))
+		if service == nil {
+			return nil
+		}
+		c := service.Cursor()
+		for k, v := c.Seek(historyKey(since, 0)); k != nil; k, v = c.Next() {
+			record, err := this.decodeValue(deviceKey, serviceKey, v)
+			if err != nil {
+				return err
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, err
+}
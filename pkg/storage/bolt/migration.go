@@ -0,0 +1,184 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"context"
+	"go.etcd.io/bbolt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// migrateLegacyKeys moves values stored by older versions in the flat BBOLT_LEGACY_BUCKET_NAME bucket
+// into the per-device buckets and removes the legacy bucket afterwards.
+// legacy keys have the form "device.service"; because they are ambiguous, they are split at the last '.'
+func (this *Store) migrateLegacyKeys() error {
+	return this.update(func(tx *bbolt.Tx) error {
+		legacy := tx.Bucket(BBOLT_LEGACY_BUCKET_NAME)
+		if legacy == nil {
+			return nil
+		}
+		log.Println("migrate legacy bolt keys")
+		root := tx.Bucket(BBOLT_BUCKET_NAME)
+		count := 0
+		err := legacy.ForEach(func(k, v []byte) error {
+			key := string(k)
+			index := strings.LastIndex(key, ".")
+			if index <= 0 || index == len(key)-1 {
+				log.Println("WARNING: drop legacy bolt key without device or service part:", key)
+				return nil
+			}
+			device, err := root.CreateBucketIfNotExists([]byte(key[:index]))
+			if err != nil {
+				return err
+			}
+			count++
+			return device.Put([]byte(key[index+1:]), append([]byte{}, v...))
+		})
+		if err != nil {
+			return err
+		}
+		log.Println("migrated", count, "legacy bolt keys")
+		return tx.DeleteBucket(BBOLT_LEGACY_BUCKET_NAME)
+	})
+}
+
+// BBOLT_META_BUCKET_NAME contains information about the database, like the record format
+var BBOLT_META_BUCKET_NAME = []byte("meta")
+
+// recordFormatKey stores the codec format of all records (see codec.Codec.Format)
+var recordFormatKey = []byte("record_format")
+
+// startRecordUpgrade rewrites values and history entries of older versions, or unencrypted records if
+// encryption is enabled, in the current binary encoding in the background. reads handle both encodings, so the upgrade may be interrupted by a shutdown;
+// it continues on the next start. when all records are upgraded, a marker skips the upgrade on later starts.
+func (this *Store) startRecordUpgrade(ctx context.Context, wg *sync.WaitGroup) (done chan struct{}) {
+	done = make(chan struct{})
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		defer close(done)
+		upgraded, err := this.upgradeRecords(ctx)
+		if err != nil {
+			log.Println("ERROR: unable to upgrade bolt records:", err)
+			return
+		}
+		if upgraded > 0 {
+			log.Println("upgraded", upgraded, "bolt records to the binary record format")
+		}
+	}()
+	return done
+}
+
+func (this *Store) upgradeRecords(ctx context.Context) (upgraded int, err error) {
+	done := false
+	err = this.view(func(tx *bbolt.Tx) error {
+		if meta := tx.Bucket(BBOLT_META_BUCKET_NAME); meta != nil {
+			done = string(meta.Get(recordFormatKey)) == this.codec.Format()
+		}
+		return nil
+	})
+	if err != nil || done {
+		return 0, err
+	}
+	devices := [][]byte{}
+	err = this.view(func(tx *bbolt.Tx) error {
+		collect := func(k []byte) error {
+			devices = append(devices, append([]byte{}, k...))
+			return nil
+		}
+		err := tx.Bucket(BBOLT_BUCKET_NAME).ForEachBucket(collect)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).ForEachBucket(collect)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, deviceKey := range devices {
+		if ctx.Err() != nil {
+			return upgraded, nil
+		}
+		err = this.update(func(tx *bbolt.Tx) error {
+			this.changed(BBOLT_BUCKET_NAME, deviceKey)
+			this.changed(BBOLT_HISTORY_BUCKET_NAME, deviceKey)
+			if device := tx.Bucket(BBOLT_BUCKET_NAME).Bucket(deviceKey); device != nil {
+				count, err := this.upgradeBucket(string(deviceKey), device, func(k []byte) string { return string(k) })
+				upgraded += count
+				if err != nil {
+					return err
+				}
+			}
+			device := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).Bucket(deviceKey)
+			if device == nil {
+				return nil
+			}
+			return device.ForEachBucket(func(serviceKey []byte) error {
+				count, err := this.upgradeBucket(string(deviceKey), device.Bucket(serviceKey), func([]byte) string { return string(serviceKey) })
+				upgraded += count
+				return err
+			})
+		})
+		if err != nil {
+			return upgraded, err
+		}
+	}
+	return upgraded, this.update(func(tx *bbolt.Tx) error {
+		this.changed(BBOLT_META_BUCKET_NAME, nil)
+		meta, err := tx.CreateBucketIfNotExists(BBOLT_META_BUCKET_NAME)
+		if err != nil {
+			return err
+		}
+		return meta.Put(recordFormatKey, []byte(this.codec.Format()))
+	})
+}
+
+// upgradeBucket re-encodes the values of the bucket that need an upgrade; unreadable values are kept
+func (this *Store) upgradeBucket(deviceKey string, bucket *bbolt.Bucket, serviceKey func(k []byte) string) (upgraded int, err error) {
+	legacy := map[string][]byte{}
+	err = bucket.ForEach(func(k, v []byte) error {
+		if v != nil && this.codec.NeedsUpgrade(v) {
+			legacy[string(k)] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range legacy {
+		record, err := this.decodeValue(deviceKey, serviceKey([]byte(k)), v)
+		if err != nil {
+			continue
+		}
+		encoded, err := this.encodeValue(record)
+		if err != nil {
+			return upgraded, err
+		}
+		err = bucket.Put([]byte(k), encoded)
+		if err != nil {
+			return upgraded, err
+		}
+		upgraded++
+	}
+	return upgraded, nil
+}
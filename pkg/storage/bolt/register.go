@@ -0,0 +1,44 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"sync"
+)
+
+func init() {
+	storage.Register("bolt", func(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, section json.RawMessage) (storage.Storage, error) {
+		config, err := storage.ApplySection(config, "bolt", section)
+		if err != nil {
+			return nil, err
+		}
+		result, err := NewWithConfig(ctx, wg, config)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	storage.RegisterFiles("bolt", func(config configuration.Config) []string {
+		//invalid sections are reported by the factory
+		config, _ = storage.ApplySection(config, "bolt", config.StorageConfig["bolt"])
+		return []string{config.BoltLocation}
+	})
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+	"log"
+)
+
+// Scan calls handler for every stored value of deviceKey or, if deviceKey is empty, of all devices.
+// records are visited ordered by device and service; buffered writes are flushed first. handler must not access the store.
+// records that can not be decoded are logged and skipped; expired records are skipped too.
+func (this *Store) Scan(deviceKey string, handler func(record model.Record) error) error {
+	err := this.Flush()
+	if err != nil {
+		return err
+	}
+	return this.scan(deviceKey, this.decodeValue, handler)
+}
+
+// ScanTimes calls handler like Scan, but the records contain only keys and times (Value is nil).
+// payloads are not decoded and buffered writes are not flushed but reported in addition, so records are not ordered.
+// handler must not access the store.
+func (this *Store) ScanTimes(deviceKey string, handler func(record model.Record) error) error {
+	buffered := map[bufferKey]model.Record{}
+	if this.buffer != nil {
+		this.buffer.mux.Lock()
+		for _, values := range []map[bufferKey]model.Record{this.buffer.flushing, this.buffer.values} {
+			for k, record := range values {
+				if (deviceKey == "" || k.deviceKey == deviceKey) && !this.expired(record) {
+					record.Value = nil
+					buffered[k] = record
+				}
+			}
+		}
+		this.buffer.mux.Unlock()
+	}
+	err := this.scan(deviceKey, this.decodeTimes, func(record model.Record) error {
+		k := bufferKey{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}
+		if newer, ok := buffered[k]; ok {
+			delete(buffered, k)
+			record = newer
+		}
+		return handler(record)
+	})
+	if err != nil {
+		return err
+	}
+	for _, record := range buffered {
+		err = handler(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan skips records that can not be decoded; decode logs the errors, scan the number of skipped records
+func (this *Store) scan(deviceKey string, decode func(deviceKey string, serviceKey string, value []byte) (model.Record, error), handler func(record model.Record) error) error {
+	skipped := 0
+	defer func() {
+		if skipped > 0 {
+			log.Println("WARNING: bolt scan skipped", skipped, "records that could not be decoded")
+		}
+	}()
+	scanDevice := func(deviceKey string, device *bbolt.Bucket) error {
+		return device.ForEach(func(k, v []byte) error {
+			record, err := decode(deviceKey, string(k), v)
+			if err != nil {
+				skipped++
+				return nil
+			}
+			if this.expired(record) {
+				return nil
+			}
+			return handler(record)
+		})
+	}
+	return this.view(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(BBOLT_BUCKET_NAME)
+		if deviceKey != "" {
+			device := root.Bucket([]byte(deviceKey))
+			if device == nil {
+				return nil
+			}
+			return scanDevice(deviceKey, device)
+		}
+		return root.ForEachBucket(func(k []byte) error {
+			return scanDevice(string(k), root.Bucket(k))
+		})
+	})
+}
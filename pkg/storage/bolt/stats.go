@@ -0,0 +1,82 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"go.etcd.io/bbolt"
+	"os"
+)
+
+func (this *Store) Stats() (result model.StorageStats, err error) {
+	result.Backend = "bolt"
+	err = this.Flush()
+	if err != nil {
+		return result, err
+	}
+	err = this.view(func(tx *bbolt.Tx) error {
+		values := tx.Bucket(BBOLT_BUCKET_NAME)
+		err := values.ForEachBucket(func(deviceKey []byte) error {
+			result.Values += values.Bucket(deviceKey).Stats().KeyN
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		history := tx.Bucket(BBOLT_HISTORY_BUCKET_NAME)
+		return history.ForEachBucket(func(deviceKey []byte) error {
+			device := history.Bucket(deviceKey)
+			return device.ForEachBucket(func(serviceKey []byte) error {
+				result.HistoryEntries += device.Bucket(serviceKey).Stats().KeyN
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return result, err
+	}
+	compression := this.codec.Stats()
+	result.Compression = &compression
+
+	this.dbMux.RLock()
+	defer this.dbMux.RUnlock()
+	stats := this.db.Stats()
+	result.Bolt = &model.BoltStats{
+		PageSize:       this.db.Info().PageSize,
+		FreePages:      stats.FreePageN,
+		PendingPages:   stats.PendingPageN,
+		FreeAlloc:      stats.FreeAlloc,
+		FreelistInuse:  stats.FreelistInuse,
+		OpenReadTx:     stats.OpenTxN,
+		LastCompaction: this.lastCompaction,
+	}
+	result.DiskSize = fileSize(this.location)
+	return result, nil
+}
+
+// Maintain compacts the database (see Compact)
+func (this *Store) Maintain() (model.MaintenanceResult, error) {
+	return this.Compact()
+}
+
+func fileSize(location string) int64 {
+	info, err := os.Stat(location)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"go.etcd.io/bbolt"
+	"log"
+	"sync"
+	"time"
+)
+
+// startSweeper periodically removes expired values, like badger does with its ttl
+func (this *Store) startSweeper(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				start := time.Now()
+				expired, err := this.Expire()
+				if err != nil {
+					log.Println("ERROR: bolt ttl sweep failed after", expired, "expired keys:", err)
+					continue
+				}
+				log.Println("bolt ttl sweep: expired", expired, "keys in", time.Since(start))
+			}
+		}
+	}()
+}
+
+// Expire removes last values last seen more than ttl ago and history entries with a time more than ttl ago.
+// unlike badger, which expires entries ttl after their write, bolt expires by the times of the records (see Expired):
+// for values received live this is the same, but values written with older times (e.g. by an import) expire earlier.
+// each device is handled in its own transaction to keep concurrent writes responsive.
+func (this *Store) Expire() (expired int, err error) {
+	if this.ttl <= 0 {
+		return 0, nil
+	}
+	err = this.Flush()
+	if err != nil {
+		return 0, err
+	}
+	limit := time.Now().Add(-this.ttl)
+	devices := [][]byte{}
+	err = this.view(func(tx *bbolt.Tx) error {
+		collect := func(k []byte) error {
+			devices = append(devices, append([]byte{}, k...))
+			return nil
+		}
+		err := tx.Bucket(BBOLT_BUCKET_NAME).ForEachBucket(collect)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(BBOLT_HISTORY_BUCKET_NAME).ForEachBucket(collect)
+	})
+	if err != nil {
+		return expired, err
+	}
+	for _, deviceKey := range devices {
+		err = this.update(func(tx *bbolt.Tx) error {
+			this.changed(BBOLT_BUCKET_NAME, deviceKey)
+			this.changed(BBOLT_HISTORY_BUCKET_NAME, deviceKey)
+			count, err := this.expireValues(tx.Bucket(BBOLT_BUCKET_NAME), deviceKey, limit)
+			expired += count
+			if err != nil {
+				return err
+			}
+			count, err = expireHistory(tx.Bucket(BBOLT_HISTORY_BUCKET_NAME), deviceKey, limit)
+			expired += count
+			return err
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+func (this *Store) expireValues(root *bbolt.Bucket, deviceKey []byte, limit time.Time) (expired int, err error) {
+	device := root.Bucket(deviceKey)
+	if device == nil {
+		return 0, nil
+	}
+	remove := [][]byte{}
+	err = device.ForEach(func(k, v []byte) error {
+		record, err := this.decodeValue(string(deviceKey), string(k), v)
+		if err != nil {
+			return nil //unreadable values are left for inspection
+		}
+		if record.LastSeen.Before(limit) {
+			remove = append(remove, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range remove {
+		err = device.Delete(k)
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	if k, _ := device.Cursor().First(); k == nil {
+		err = root.DeleteBucket(deviceKey)
+	}
+	return expired, err
+}
+
+func expireHistory(root *bbolt.Bucket, deviceKey []byte, limit time.Time) (expired int, err error) {
+	device := root.Bucket(deviceKey)
+	if device == nil {
+		return 0, nil
+	}
+	limitKey := historyKey(limit, 0)
+	services := [][]byte{}
+	err = device.ForEachBucket(func(k []byte) error {
+		services = append(services, append([]byte{}, k...))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, serviceKey := range services {
+		service := device.Bucket(serviceKey)
+		remove := [][]byte{}
+		c := service.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, limitKey) < 0; k, _ = c.Next() {
+			remove = append(remove, append([]byte{}, k...))
+		}
+		for _, k := range remove {
+			err = service.Delete(k)
+			if err != nil {
+				return expired, err
+			}
+			expired++
+		}
+		if k, _ := service.Cursor().First(); k == nil {
+			err = device.DeleteBucket(serviceKey)
+			if err != nil {
+				return expired, err
+			}
+		}
+	}
+	if k, _ := device.Cursor().First(); k == nil {
+		err = root.DeleteBucket(deviceKey)
+	}
+	return expired, err
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"go.etcd.io/bbolt"
+	"log"
+	"time"
+)
+
+// open opens the bolt file at location. only damaged files are marked as corrupt: invalid meta pages,
+// checksum or version mismatches, truncated files and panics of bbolt on damaged pages. all other errors (e.g. missing permissions,
+// a full or read-only file system, a directory at location or a lock timeout) are returned unchanged.
+func open(location string) (db *bbolt.DB, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = storage.Corrupt(errors.New("unable to open bolt file: " + fmt.Sprint(r)))
+		}
+	}()
+	db, err = bbolt.Open(location, 0666, nil)
+	//bbolt does not export the error of truncated files
+	if errors.Is(err, bbolt.ErrInvalid) || errors.Is(err, bbolt.ErrChecksum) || errors.Is(err, bbolt.ErrVersionMismatch) || err != nil && err.Error() == "file size too small" {
+		return db, storage.Corrupt(err)
+	}
+	return db, err
+}
+
+// verify reads every bucket, key and value, which makes bbolt check every reachable page.
+// bbolt panics on damaged pages; the panics are returned as error. (tx.Check is not used, it panics in its own goroutine)
+func (this *Store) verify() (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("bolt verification failed: " + fmt.Sprint(r))
+		}
+	}()
+	err = this.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			return verifyBucket(bucket)
+		})
+	})
+	if err != nil {
+		return errors.New("bolt verification failed: " + err.Error())
+	}
+	log.Println("verified bolt file in", time.Since(start))
+	return nil
+}
+
+func verifyBucket(bucket *bbolt.Bucket) error {
+	return bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		nested := bucket.Bucket(k)
+		if nested == nil {
+			return errors.New("missing nested bucket " + string(k))
+		}
+		return verifyBucket(nested)
+	})
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"log"
+	"sync"
+	"time"
+)
+
+// Codec encodes records like Encode, compresses payloads larger than the threshold
+// and encrypts records if a key is set. payloads are stored uncompressed if the compression does not reduce their size.
+type Codec struct {
+	compression string
+	threshold   int
+	aead        cipher.AEAD //nil without encryption
+	stats       stats
+}
+
+// NewCodec returns a Codec for compression "" (none), "gzip" or "zstd".
+// with an encryption key (16, 24 or 32 bytes; see LoadKey), records are encrypted with AES-GCM.
+func NewCodec(compression string, threshold int64, encryptionKey []byte) (*Codec, error) {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, errors.New("unknown compression " + compression)
+	}
+	if threshold < 0 {
+		return nil, errors.New("compression threshold must not be negative")
+	}
+	result := &Codec{compression: compression, threshold: int(threshold)}
+	if len(encryptionKey) > 0 {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		result.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// WithKey returns a Codec with the same compression and another encryption key (nil: no encryption)
+func (this *Codec) WithKey(encryptionKey []byte) (*Codec, error) {
+	return NewCodec(this.compression, int64(this.threshold), encryptionKey)
+}
+
+func (this *Codec) Encrypted() bool {
+	return this.aead != nil
+}
+
+// Format identifies the encoding of new records; stored records with another format are upgraded (see NeedsUpgrade)
+func (this *Codec) Format() string {
+	if this.Encrypted() {
+		return "1+aes-gcm"
+	}
+	return "1"
+}
+
+// NeedsUpgrade returns true for legacy records and, with encryption, for unencrypted records
+func (this *Codec) NeedsUpgrade(data []byte) bool {
+	return IsLegacy(data) || (this.Encrypted() && !IsEncrypted(data))
+}
+
+func (this *Codec) Encode(record model.Record) ([]byte, error) {
+	payload := record.Value
+	flags := byte(0)
+	if this.compression != CompressionNone && len(payload) > this.threshold {
+		compressed, flag, err := compress(this.compression, payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			payload = compressed
+			flags = flag
+			this.stats.compressed.Add(1)
+		}
+	}
+	this.stats.records.Add(1)
+	this.stats.payloadBytes.Add(int64(len(record.Value)))
+	this.stats.storedBytes.Add(int64(len(payload)))
+	result := encode(model.Record{Value: payload, Time: record.Time, Received: record.Received, LastSeen: record.LastSeen}, flags)
+	if this.Encrypted() {
+		return seal(this.aead, result, record.DeviceKey, record.ServiceKey)
+	}
+	return result, nil
+}
+
+// Decode reads records like the package function Decode and decrypts encrypted records
+func (this *Codec) Decode(deviceKey string, serviceKey string, data []byte) (result model.Record, err error) {
+	if IsEncrypted(data) {
+		if !this.Encrypted() {
+			return result, errors.New("record is encrypted, but no encryption key is configured")
+		}
+		data, err = open(this.aead, data, deviceKey, serviceKey)
+		if err != nil {
+			return result, err
+		}
+	}
+	return Decode(deviceKey, serviceKey, data)
+}
+
+// DecodeTimes reads records like the package function DecodeTimes; encrypted records are decrypted, because their times are encrypted too
+func (this *Codec) DecodeTimes(deviceKey string, serviceKey string, data []byte) (result model.Record, err error) {
+	if IsEncrypted(data) {
+		if !this.Encrypted() {
+			return result, errors.New("record is encrypted, but no encryption key is configured")
+		}
+		data, err = open(this.aead, data, deviceKey, serviceKey)
+		if err != nil {
+			return result, err
+		}
+	}
+	return DecodeTimes(deviceKey, serviceKey, data)
+}
+
+func (this *Codec) Stats() Stats {
+	result := Stats{
+		Compression:       this.compression,
+		Records:           this.stats.records.Load(),
+		CompressedRecords: this.stats.compressed.Load(),
+		PayloadBytes:      this.stats.payloadBytes.Load(),
+		StoredBytes:       this.stats.storedBytes.Load(),
+	}
+	result.SavedBytes = result.PayloadBytes - result.StoredBytes
+	if result.PayloadBytes > 0 {
+		result.SavedRatio = float64(result.SavedBytes) / float64(result.PayloadBytes)
+	}
+	return result
+}
+
+// StartStatsLog logs the compression statistics every interval and on shutdown, if compression is enabled
+func (this *Codec) StartStatsLog(ctx context.Context, wg *sync.WaitGroup, name string, interval time.Duration) {
+	if this.compression == CompressionNone {
+		return
+	}
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				this.logStats(name)
+				return
+			case <-ticker.C:
+				this.logStats(name)
+			}
+		}
+	}()
+}
+
+func (this *Codec) logStats(name string) {
+	stats := this.Stats()
+	log.Printf("%v %v compression: %v of %v payloads compressed, %v of %v bytes saved (%.1f%%)\n", name, stats.Compression, stats.CompressedRecords, stats.Records, stats.SavedBytes, stats.PayloadBytes, stats.SavedRatio*100)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// payload compression flags of the record header
+const (
+	FlagGzip byte = 1 << 0
+	FlagZstd byte = 1 << 1
+)
+
+// zstd encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+type stats struct {
+	records      atomic.Int64
+	compressed   atomic.Int64
+	payloadBytes atomic.Int64
+	storedBytes  atomic.Int64
+}
+
+// Stats describe the payloads encoded since start
+type Stats = model.CompressionStats
+
+func compress(compression string, payload []byte) (result []byte, flag byte, err error) {
+	switch compression {
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(payload, nil), FlagZstd, nil
+	case CompressionGzip:
+		buf := &bytes.Buffer{}
+		writer := gzip.NewWriter(buf)
+		_, err = writer.Write(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		err = writer.Close()
+		return buf.Bytes(), FlagGzip, err
+	}
+	return nil, 0, errors.New("unknown compression " + compression)
+}
+
+func decompress(flags byte, payload []byte) ([]byte, error) {
+	switch {
+	case flags&FlagZstd != 0:
+		return zstdDecoder.DecodeAll(payload, nil)
+	case flags&FlagGzip != 0:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	}
+	return append([]byte{}, payload...), nil
+}
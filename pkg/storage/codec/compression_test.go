@@ -0,0 +1,73 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	large := []byte(`{"state":"` + strings.Repeat("on,", 1000) + `"}`)
+	small := []byte(`{"value":42}`)
+	random := make([]byte, 2048)
+	_, err := rand.Read(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			recordCodec, err := NewCodec(compression, 100, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, payload := range [][]byte{large, small, random} {
+				now := time.Now()
+				encoded, err := recordCodec.Encode(model.Record{Value: payload, Time: now, Received: now, LastSeen: now})
+				if err != nil {
+					t.Fatal(err)
+				}
+				compressed := encoded[1]&(FlagGzip|FlagZstd) != 0
+				if compressed != bytes.Equal(payload, large) {
+					t.Error("unexpected compression", compressed, len(payload))
+				}
+				record, err := Decode("d", "s", encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(record.Value, payload) || !record.Time.Equal(now) {
+					t.Error(len(record.Value), len(payload), record.Time)
+				}
+			}
+			stats := recordCodec.Stats()
+			if stats.Records != 3 || stats.CompressedRecords != 1 || stats.SavedBytes <= 0 ||
+				stats.PayloadBytes != int64(len(large)+len(small)+len(random)) || stats.SavedBytes != stats.PayloadBytes-stats.StoredBytes {
+				t.Error(stats)
+			}
+		})
+	}
+
+	_, err = NewCodec("lz4", 0, nil)
+	if err == nil {
+		t.Error("expected error for unknown compression")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// FlagEncrypted marks records where everything after the header is encrypted with AES-GCM:
+//
+//	version | flags | nonce | sealed(times | payload)
+//
+// header, device and service are authenticated, so records can not be moved to other keys unnoticed.
+const FlagEncrypted byte = 1 << 2
+
+// IsEncrypted returns true if data is an encrypted binary record
+func IsEncrypted(data []byte) bool {
+	return !IsLegacy(data) && len(data) >= 2 && data[1]&FlagEncrypted != 0
+}
+
+// LoadKey reads the encryption key from keyFile or, if keyFile is empty, from key.
+// keys are given hex or base64 encoded; key files may also contain the raw key.
+// valid keys have 16, 24 or 32 bytes (AES-128, AES-192 or AES-256). no key returns nil.
+func LoadKey(key string, keyFile string) (result []byte, err error) {
+	if key != "" && keyFile != "" {
+		return nil, errors.New("encryption_key and encryption_key_file must not be used together")
+	}
+	raw := []byte(key)
+	if keyFile != "" {
+		raw, err = os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.New("unable to read encryption key file:" + err.Error())
+		}
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if validKeyLength(len(raw)) && keyFile != "" {
+		return raw, nil
+	}
+	text := strings.TrimSpace(string(raw))
+	if result, err = hex.DecodeString(text); err == nil && validKeyLength(len(result)) {
+		return result, nil
+	}
+	if result, err = base64.StdEncoding.DecodeString(text); err == nil && validKeyLength(len(result)) {
+		return result, nil
+	}
+	return nil, errors.New("invalid encryption key: expected 16, 24 or 32 bytes, hex or base64 encoded")
+}
+
+func validKeyLength(length int) bool {
+	return length == 16 || length == 24 || length == 32
+}
+
+func seal(aead cipher.AEAD, data []byte, deviceKey string, serviceKey string) ([]byte, error) {
+	header := []byte{data[0], data[1] | FlagEncrypted}
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, len(header)+len(nonce)+len(data)+aead.Overhead())
+	result = append(result, header...)
+	result = append(result, nonce...)
+	return aead.Seal(result, nonce, data[2:], additionalData(header, deviceKey, serviceKey)), nil
+}
+
+// open returns the unencrypted record
+func open(aead cipher.AEAD, data []byte, deviceKey string, serviceKey string) ([]byte, error) {
+	if len(data) < 2+aead.NonceSize() {
+		return nil, errors.New("encrypted record too short")
+	}
+	header := data[:2]
+	nonce := data[2 : 2+aead.NonceSize()]
+	result := []byte{header[0], header[1] &^ FlagEncrypted}
+	result, err := aead.Open(result, nonce, data[2+aead.NonceSize():], additionalData(header, deviceKey, serviceKey))
+	if err != nil {
+		return nil, errors.New("unable to decrypt record (wrong encryption key?): " + err.Error())
+	}
+	return result, nil
+}
+
+func additionalData(header []byte, deviceKey string, serviceKey string) []byte {
+	result := append([]byte{}, header...)
+	result = binary.AppendUvarint(result, uint64(len(deviceKey)))
+	result = append(result, deviceKey...)
+	return append(result, serviceKey...)
+}
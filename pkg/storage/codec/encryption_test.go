@@ -0,0 +1,126 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	recordCodec, err := NewCodec(CompressionZstd, 10, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	payload := []byte(`{"meter":"secret reading","value":123456789}`)
+	encoded, err := recordCodec.Encode(model.Record{DeviceKey: "d", ServiceKey: "s", Value: payload, Time: now, Received: now, LastSeen: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encoded) || bytes.Contains(encoded, []byte("secret")) || !recordCodec.NeedsUpgrade([]byte("{}")) || recordCodec.NeedsUpgrade(encoded) {
+		t.Error("record not encrypted")
+	}
+
+	record, err := recordCodec.Decode("d", "s", encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(record.Value, payload) || !record.Time.Equal(now) {
+		t.Error(record)
+	}
+
+	_, err = recordCodec.Decode("d", "other", encoded)
+	if err == nil {
+		t.Error("record moved to another key should not be readable")
+	}
+	_, err = Decode("d", "s", encoded)
+	if err == nil {
+		t.Error("encrypted record should not be readable without key")
+	}
+	otherCodec, err := recordCodec.WithKey(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = otherCodec.Decode("d", "s", encoded)
+	if err == nil {
+		t.Error("encrypted record should not be readable with another key")
+	}
+
+	plainCodec, err := recordCodec.WithKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := plainCodec.Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recordCodec.NeedsUpgrade(plain) || plainCodec.NeedsUpgrade(plain) {
+		t.Error("unencrypted records should be upgraded with encryption")
+	}
+	record, err = recordCodec.Decode("d", "s", plain)
+	if err != nil || !bytes.Equal(record.Value, payload) {
+		t.Error("unencrypted records should be readable with encryption", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	keyFile := t.TempDir() + "/key"
+	err := os.WriteFile(keyFile, key, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hexFile := t.TempDir() + "/key.hex"
+	err = os.WriteFile(hexFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ key, file string }{
+		{key: hex.EncodeToString(key)},
+		{key: base64.StdEncoding.EncodeToString(key)},
+		{file: keyFile},
+		{file: hexFile},
+	} {
+		result, err := LoadKey(test.key, test.file)
+		if err != nil || !bytes.Equal(result, key) {
+			t.Error(test, result, err)
+		}
+	}
+
+	result, err := LoadKey("", "")
+	if err != nil || result != nil {
+		t.Error(result, err)
+	}
+	for _, test := range []struct{ key, file string }{
+		{key: "too short"},
+		{key: hex.EncodeToString(key[:10])},
+		{key: hex.EncodeToString(key), file: keyFile},
+		{file: t.TempDir() + "/missing"},
+	} {
+		_, err = LoadKey(test.key, test.file)
+		if err == nil {
+			t.Error("expected error", test)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package codec implements the binary encoding of stored values, shared by the persistent backends.
+//
+// Version 1 layout:
+//
+//	version (1 byte) | flags (1 byte) | time | received | last seen | payload
+//
+// each time is encoded as varint unix seconds followed by uvarint nanoseconds.
+// the payload takes the remaining bytes; it is compressed if the flags contain FlagGzip or FlagZstd.
+// with FlagEncrypted, times and payload are encrypted (see FlagEncrypted); such records are read by Codec.Decode.
+// values of older versions are json encoded ValueWithTime objects and always start with '{'.
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"time"
+)
+
+const Version1 byte = 1
+
+// ValueWithTime is the legacy json encoding; Received and LastSeen are zero in values of older versions
+type ValueWithTime struct {
+	Value    []byte    `json:"v"`
+	Time     time.Time `json:"t"`
+	Received time.Time `json:"r"`
+	LastSeen time.Time `json:"s"`
+}
+
+// knownFlags are the flags this version can decode
+const knownFlags = FlagGzip | FlagZstd
+
+// Encode returns the current binary encoding of the record without compression;
+// device and service are part of the key and not encoded
+func Encode(record model.Record) ([]byte, error) {
+	return encode(record, 0), nil
+}
+
+func encode(record model.Record, flags byte) []byte {
+	result := make([]byte, 0, 2+3*(binary.MaxVarintLen64+binary.MaxVarintLen32)+len(record.Value))
+	result = append(result, Version1, flags)
+	result = appendTime(result, record.Time)
+	result = appendTime(result, record.Received)
+	result = appendTime(result, record.LastSeen)
+	return append(result, record.Value...)
+}
+
+// IsLegacy returns true if data is encoded in the legacy json format
+func IsLegacy(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// Decode reads the binary and the legacy json encoding.
+// missing receive times default to the value time, last seen times are never before the receive time.
+// the payload is copied, so data may be reused by the caller.
+func Decode(deviceKey string, serviceKey string, data []byte) (result model.Record, err error) {
+	return decode(deviceKey, serviceKey, data, true)
+}
+
+// DecodeTimes reads the times of a record like Decode; the payload is neither decompressed nor copied and Value stays nil
+func DecodeTimes(deviceKey string, serviceKey string, data []byte) (result model.Record, err error) {
+	return decode(deviceKey, serviceKey, data, false)
+}
+
+func decode(deviceKey string, serviceKey string, data []byte, withValue bool) (result model.Record, err error) {
+	result.DeviceKey = deviceKey
+	result.ServiceKey = serviceKey
+	if IsLegacy(data) {
+		err = decodeLegacy(data, &result)
+		if !withValue {
+			result.Value = nil
+		}
+	} else {
+		err = decodeV1(data, &result, withValue)
+	}
+	if err != nil {
+		return result, err
+	}
+	if result.Received.IsZero() {
+		result.Received = result.Time
+	}
+	if result.LastSeen.Before(result.Received) {
+		result.LastSeen = result.Received
+	}
+	return result, nil
+}
+
+func decodeLegacy(data []byte, result *model.Record) error {
+	value := ValueWithTime{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	result.Value = value.Value
+	result.Time = value.Time
+	result.Received = value.Received
+	result.LastSeen = value.LastSeen
+	return nil
+}
+
+func decodeV1(data []byte, result *model.Record, withValue bool) (err error) {
+	if len(data) < 2 {
+		return errors.New("record too short")
+	}
+	if data[0] != Version1 {
+		return fmt.Errorf("unknown record version %v", data[0])
+	}
+	if data[1]&FlagEncrypted != 0 {
+		return errors.New("record is encrypted")
+	}
+	if data[1]&^knownFlags != 0 {
+		return fmt.Errorf("unknown record flags %b", data[1])
+	}
+	rest := data[2:]
+	for _, t := range []*time.Time{&result.Time, &result.Received, &result.LastSeen} {
+		*t, rest, err = readTime(rest)
+		if err != nil {
+			return err
+		}
+	}
+	if !withValue {
+		return nil
+	}
+	result.Value, err = decompress(data[1], rest)
+	return err
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	buf = binary.AppendVarint(buf, t.Unix())
+	return binary.AppendUvarint(buf, uint64(t.Nanosecond()))
+}
+
+func readTime(buf []byte) (result time.Time, rest []byte, err error) {
+	sec, n := binary.Varint(buf)
+	if n <= 0 {
+		return result, nil, errors.New("invalid record time")
+	}
+	nsec, m := binary.Uvarint(buf[n:])
+	if m <= 0 || nsec >= uint64(time.Second) {
+		return result, nil, errors.New("invalid record time")
+	}
+	//zero times are read back as zero times, time.Unix(time.Time{}.Unix(), 0).IsZero() is true
+	return time.Unix(sec, int64(nsec)), buf[n+m:], nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	now := time.Now()
+	records := []model.Record{
+		{Value: []byte(`{"value":42}`), Time: now.Add(-time.Hour), Received: now, LastSeen: now.Add(time.Minute)},
+		{Value: []byte{0xff, 0x00}, Time: time.Unix(0, 1), Received: now, LastSeen: now},
+		{Value: []byte{}, Time: time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), Received: now, LastSeen: now},
+	}
+	for _, expected := range records {
+		encoded, err := Encode(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsLegacy(encoded) {
+			t.Error("encoded value detected as legacy")
+		}
+		actual, err := Decode("d", "s", encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.DeviceKey != "d" || actual.ServiceKey != "s" || !bytes.Equal(actual.Value, expected.Value) ||
+			!actual.Time.Equal(expected.Time) || !actual.Received.Equal(expected.Received) || !actual.LastSeen.Equal(expected.LastSeen) {
+			t.Error(actual, expected)
+		}
+	}
+}
+
+func TestDecodeTimes(t *testing.T) {
+	now := time.Now()
+	expected := model.Record{DeviceKey: "d", ServiceKey: "s", Value: bytes.Repeat([]byte("42"), 1000), Time: now.Add(-time.Hour), Received: now, LastSeen: now.Add(time.Minute)}
+	for _, compression := range []string{CompressionNone, CompressionZstd} {
+		for _, key := range [][]byte{nil, bytes.Repeat([]byte{1}, 32)} {
+			c, err := NewCodec(compression, 0, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := c.Encode(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := c.DecodeTimes("d", "s", encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual.DeviceKey != "d" || actual.ServiceKey != "s" || actual.Value != nil ||
+				!actual.Time.Equal(expected.Time) || !actual.Received.Equal(expected.Received) || !actual.LastSeen.Equal(expected.LastSeen) {
+				t.Error(compression, key != nil, actual)
+			}
+		}
+	}
+	legacy, err := DecodeTimes("d", "s", []byte(`{"v":"NDI=","t":"2024-01-01T00:00:00Z"}`))
+	if err != nil || legacy.Value != nil || legacy.Time.IsZero() || !legacy.Received.Equal(legacy.Time) {
+		t.Error(legacy, err)
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	now := time.Now()
+	legacy, err := json.Marshal(ValueWithTime{Value: []byte("42"), Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLegacy(legacy) {
+		t.Fatal("legacy value not detected")
+	}
+	record, err := Decode("d", "s", legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(record.Value) != "42" || !record.Time.Equal(now) || !record.Received.Equal(now) || !record.LastSeen.Equal(now) {
+		t.Error(record)
+	}
+
+	encoded, err := Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) >= len(legacy) {
+		t.Error("binary record not smaller than legacy record", len(encoded), len(legacy))
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range [][]byte{{}, {Version1}, {2, 0}, {Version1, 0x80}, {Version1, 0, 0x80}} {
+		_, err := Decode("d", "s", data)
+		if err == nil {
+			t.Error("expected error for", data)
+		}
+	}
+}
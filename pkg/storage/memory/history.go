@@ -0,0 +1,76 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"sort"
+	"time"
+)
+
+func (this *Store) historyEnabled() bool {
+	return this.historyLength > 0 || this.historyMaxAge > 0
+}
+
+// appendHistory inserts the record ordered by time and removes entries older than historyMaxAge
+// and the oldest entries exceeding historyLength. this.mux has to be locked by the caller.
+// the stored lists are never modified in place, so they may be shared with snapshots.
+func (this *Store) appendHistory(k key, record model.Record) {
+	if !this.historyEnabled() {
+		return
+	}
+	old := this.history[k]
+	index := sort.Search(len(old), func(i int) bool {
+		return old[i].Time.After(record.Time)
+	})
+	list := make([]model.Record, 0, len(old)+1)
+	list = append(list, old[:index]...)
+	list = append(list, record)
+	list = append(list, old[index:]...)
+
+	remove := 0
+	if this.historyLength > 0 && len(list) > this.historyLength {
+		remove = len(list) - this.historyLength
+	}
+	if this.historyMaxAge > 0 {
+		limit := time.Now().Add(-this.historyMaxAge)
+		for remove < len(list) && list[remove].Time.Before(limit) {
+			remove++
+		}
+	}
+	this.history[k] = list[remove:]
+}
+
+// History returns the stored history entries of a device and service since the given time (and within historyMaxAge) in chronological order.
+// if limit > 0, only the newest limit entries are returned.
+func (this *Store) History(deviceKey string, serviceKey string, since time.Time, limit int) (result []model.Record, err error) {
+	//entries older than historyMaxAge are only removed by the next write to the key
+	if this.historyMaxAge > 0 && since.Before(time.Now().Add(-this.historyMaxAge)) {
+		since = time.Now().Add(-this.historyMaxAge)
+	}
+	this.mux.RLock()
+	defer this.mux.RUnlock()
+	list := this.history[key{deviceKey: deviceKey, serviceKey: serviceKey}]
+	index := sort.Search(len(list), func(i int) bool {
+		return !list[i].Time.Before(since)
+	})
+	list = list[index:]
+	if limit > 0 && len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return append([]model.Record{}, list...), nil
+}
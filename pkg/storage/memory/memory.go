@@ -0,0 +1,249 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store keeps all values in memory. if a snapshot location is configured, the values are written to this file
+// in the configured interval (only if changed) and on shutdown, and loaded from it on start.
+type Store struct {
+	mux           sync.RWMutex
+	values        map[key]model.Record
+	history       map[key][]model.Record
+	historyLength int
+	historyMaxAge time.Duration
+	location      string
+	changed       bool
+}
+
+type key struct {
+	deviceKey  string
+	serviceKey string
+}
+
+func NewWithConfig(ctx context.Context, wg *sync.WaitGroup, config configuration.Config) (result *Store, err error) {
+	if config.EncryptionKey != "" || config.EncryptionKeyFile != "" {
+		log.Println("WARNING: memory snapshots are not encrypted; the encryption key is ignored")
+	}
+	return New(ctx, wg, config.MemorySnapshotLocation, config.MemorySnapshotInterval, config.HistoryLength, config.HistoryMaxAge)
+}
+
+func New(ctx context.Context, wg *sync.WaitGroup, location string, intervalStr string, historyLength int64, historyMaxAgeStr string) (result *Store, err error) {
+	log.Println("start memory storage")
+	result = &Store{
+		values:        map[key]model.Record{},
+		history:       map[key][]model.Record{},
+		historyLength: int(historyLength),
+		location:      location,
+	}
+	if historyMaxAgeStr != "" {
+		result.historyMaxAge, err = time.ParseDuration(historyMaxAgeStr)
+		if err != nil {
+			return result, errors.New("unable to parse history max age as duration:" + err.Error())
+		}
+	}
+	if location == "" {
+		log.Println("WARNING: no memory_snapshot_location configured --> values are lost on shutdown")
+		return result, nil
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return result, errors.New("unable to parse memory snapshot interval as duration:" + err.Error())
+	}
+	err = result.loadSnapshot()
+	if err != nil {
+		return result, err
+	}
+
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				err := result.snapshot()
+				if err != nil {
+					log.Println("ERROR: unable to write memory snapshot on shutdown:", err)
+				}
+				return
+			case <-ticker.C:
+				err := result.snapshot()
+				if err != nil {
+					log.Println("ERROR: unable to write memory snapshot:", err)
+				}
+			}
+		}
+	}()
+
+	return result, nil
+}
+
+func (this *Store) Set(record model.Record) error {
+	_, err := this.set(record, false)
+	return err
+}
+
+// SetIfNewer replaces the last value only if the stored value is not newer than the record (see model.Record.OrderTime).
+// the record is added to the history in both cases.
+func (this *Store) SetIfNewer(record model.Record) (stored bool, err error) {
+	return this.set(record, true)
+}
+
+func (this *Store) set(record model.Record, onlyIfNewer bool) (stored bool, err error) {
+	k := key{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}
+	record.Value = append([]byte{}, record.Value...)
+	if record.LastSeen.Before(record.Received) {
+		record.LastSeen = record.Received
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.changed = true
+	this.appendHistory(k, record)
+	if current, ok := this.values[k]; ok && onlyIfNewer && current.OrderTime().After(record.OrderTime()) {
+		return false, nil
+	}
+	this.values[k] = record
+	return true, nil
+}
+
+// Touch sets the last seen time of the stored value, if seen is after it; missing values are ignored
+func (this *Store) Touch(deviceKey string, serviceKey string, seen time.Time) error {
+	k := key{deviceKey: deviceKey, serviceKey: serviceKey}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	record, ok := this.values[k]
+	if !ok || !seen.After(record.LastSeen) {
+		return nil
+	}
+	record.LastSeen = seen
+	this.values[k] = record
+	this.changed = true
+	return nil
+}
+
+// Get returns found == false if no value is stored for the device and service
+func (this *Store) Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error) {
+	this.mux.RLock()
+	defer this.mux.RUnlock()
+	record, found = this.values[key{deviceKey: deviceKey, serviceKey: serviceKey}]
+	return record, found, nil
+}
+
+// Scan calls handler for every stored value of deviceKey or, if deviceKey is empty, of all devices.
+// records are visited ordered by device and service. handler is called without lock and may write to the store.
+func (this *Store) Scan(deviceKey string, handler func(record model.Record) error) error {
+	return this.scan(deviceKey, true, handler)
+}
+
+// ScanTimes calls handler like Scan, but the records contain only keys and times (Value is nil)
+func (this *Store) ScanTimes(deviceKey string, handler func(record model.Record) error) error {
+	return this.scan(deviceKey, false, handler)
+}
+
+func (this *Store) scan(deviceKey string, withValue bool, handler func(record model.Record) error) error {
+	this.mux.RLock()
+	records := []model.Record{}
+	for k, record := range this.values {
+		if deviceKey == "" || k.deviceKey == deviceKey {
+			if !withValue {
+				record.Value = nil
+			}
+			records = append(records, record)
+		}
+	}
+	this.mux.RUnlock()
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].DeviceKey != records[j].DeviceKey {
+			return records[i].DeviceKey < records[j].DeviceKey
+		}
+		return records[i].ServiceKey < records[j].ServiceKey
+	})
+	for _, record := range records {
+		err := handler(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the value of the device service and its history; deleted is false if no value was stored
+func (this *Store) Delete(deviceKey string, serviceKey string) (deleted bool, err error) {
+	count, err := this.delete(func(k key) bool {
+		return k.deviceKey == deviceKey && k.serviceKey == serviceKey
+	})
+	return count > 0, err
+}
+
+// DeletePrefix removes all values of the device and their history; deleted is the number of removed values
+func (this *Store) DeletePrefix(deviceKey string) (deleted int, err error) {
+	return this.delete(func(k key) bool {
+		return k.deviceKey == deviceKey
+	})
+}
+
+func (this *Store) delete(match func(k key) bool) (deleted int, err error) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for k := range this.values {
+		if match(k) {
+			delete(this.values, k)
+			deleted++
+		}
+	}
+	for k := range this.history {
+		if match(k) {
+			delete(this.history, k)
+		}
+	}
+	this.changed = true
+	return deleted, nil
+}
+
+// Stats reports the number of values and history entries; DiskSize is the size of the snapshot file
+func (this *Store) Stats() (result model.StorageStats, err error) {
+	this.mux.RLock()
+	result.Backend = "memory"
+	result.Values = len(this.values)
+	for _, entries := range this.history {
+		result.HistoryEntries += len(entries)
+	}
+	this.mux.RUnlock()
+	if this.location != "" {
+		info, err := os.Stat(this.location)
+		if err == nil {
+			result.DiskSize = info.Size()
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	location := t.TempDir() + "/snapshot.json"
+	t.Run("write", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, "1h", 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []string{"1", "2"} {
+			err = store.Set(testRecord("a.b", "c", []byte(value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.Set(testRecord("a", "b.c", []byte("3")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("read", func(t *testing.T) {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, err := New(ctx, wg, location, "1h", 10, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, store, "a.b", "c", "2")
+		checkValue(t, store, "a", "b.c", "3")
+		checkMissing(t, store, "a", "b")
+		checkHistory(t, store, "a.b", "c", time.Time{}, 0, "1", "2")
+	})
+}
+
+func TestScan(t *testing.T) {
+	store, err := New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"b", "s1"}, {"a", "s2"}, {"a.b", "s1"}, {"a", "s1"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte(key[0]+"/"+key[1])))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := func(deviceKey string) (result []string) {
+		err = store.Scan(deviceKey, func(record model.Record) error {
+			result = append(result, string(record.Value))
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return result
+	}
+	if result := scan(""); !reflect.DeepEqual(result, []string{"a/s1", "a/s2", "a.b/s1", "b/s1"}) {
+		t.Error(result)
+	}
+	if result := scan("a"); !reflect.DeepEqual(result, []string{"a/s1", "a/s2"}) {
+		t.Error(result)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store, err := New(context.Background(), nil, "", "", 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"1", "2", "3", "4", "5"} {
+		err = store.Set(testRecord("d", "s", []byte(value)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "3", "4", "5")
+	checkHistory(t, store, "d", "s", time.Time{}, 2, "4", "5")
+	checkHistory(t, store, "d", "s", time.Now(), 0)
+	checkHistory(t, store, "d", "unknown", time.Time{}, 0)
+
+	store.historyMaxAge = 100 * time.Millisecond
+	time.Sleep(200 * time.Millisecond)
+	checkHistory(t, store, "d", "s", time.Time{}, 0)
+	err = store.Set(testRecord("d", "s", []byte("6")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "6")
+}
+
+func TestTouch(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := New(ctx, wg, "", "", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := testRecord("d", "s", []byte("1"))
+	err = store.Set(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().Add(time.Minute)
+	err = store.Touch("d", "s", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "s", seen.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Touch("d", "missing", seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, found, err := store.Get("d", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(record.Value) != "1" || !record.LastSeen.Equal(seen) || !record.Received.Equal(initial.Received) {
+		t.Error(found, record)
+	}
+	_, found, err = store.Get("d", "missing")
+	if err != nil || found {
+		t.Error(found, err)
+	}
+	checkHistory(t, store, "d", "s", time.Time{}, 0, "1")
+}
+
+func TestSetIfNewerConcurrent(t *testing.T) {
+	store, err := New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	writers := sync.WaitGroup{}
+	for _, i := range rand.Perm(50) {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			record := testRecord("d", "s", []byte(strconv.Itoa(i)))
+			record.Time = base.Add(time.Duration(i) * time.Second)
+			_, err := store.SetIfNewer(record)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	writers.Wait()
+	checkValue(t, store, "d", "s", "49")
+}
+
+func checkHistory(t *testing.T, store *Store, deviceKey string, serviceKey string, since time.Time, limit int, expected ...string) {
+	t.Helper()
+	history, err := store.History(deviceKey, serviceKey, since, limit)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	actual := []string{}
+	for _, record := range history {
+		actual = append(actual, string(record.Value))
+	}
+	if len(expected) == 0 {
+		expected = []string{}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Error(actual, expected)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store, err := New(context.Background(), nil, "", "", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"d", "s"}, {"d", "s2"}, {"d2", "s"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte(key[0]+key[1])))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted, err := store.Delete("d", "s")
+	if err != nil || !deleted {
+		t.Error(deleted, err)
+	}
+	deleted, err = store.Delete("d", "s")
+	if err != nil || deleted {
+		t.Error(deleted, err)
+	}
+	checkMissing(t, store, "d", "s")
+	checkHistory(t, store, "d", "s", time.Time{}, 0)
+	checkValue(t, store, "d", "s2", "ds2")
+
+	count, err := store.DeletePrefix("d")
+	if err != nil || count != 1 {
+		t.Error(count, err)
+	}
+	checkMissing(t, store, "d", "s2")
+	checkHistory(t, store, "d", "s2", time.Time{}, 0)
+	checkValue(t, store, "d2", "s", "d2s")
+	checkHistory(t, store, "d2", "s", time.Time{}, 0, "d2s")
+}
+
+func TestSetIfNewerFutureTime(t *testing.T) {
+	store, err := New(context.Background(), nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a wrong future time must not block later values
+	future := testRecord("d", "s", []byte("future"))
+	future.Received = future.Received.Add(-time.Hour)
+	future.Time = future.Received.Add(24 * time.Hour)
+	tolerated := testRecord("d", "s", []byte("tolerated"))
+	tolerated.Time = tolerated.Received.Add(model.MaxFutureTime / 2)
+	older := testRecord("d", "s", []byte("older"))
+	older.Time = older.Time.Add(-time.Minute)
+	for _, step := range []struct {
+		record model.Record
+		stored bool
+	}{{future, true}, {testRecord("d", "s", []byte("now")), true}, {tolerated, true}, {older, false}} {
+		stored, err := store.SetIfNewer(step.record)
+		if err != nil || stored != step.stored {
+			t.Error(string(step.record.Value), stored, err)
+		}
+	}
+	checkValue(t, store, "d", "s", "tolerated")
+}
+
+func checkValue(t *testing.T, store *Store, deviceKey string, serviceKey string, expected string) {
+	t.Helper()
+	record, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !found || string(record.Value) != expected || record.Time.IsZero() {
+		t.Error(deviceKey, serviceKey, found, record, expected)
+	}
+}
+
+func checkMissing(t *testing.T, store *Store, deviceKey string, serviceKey string) {
+	t.Helper()
+	_, found, err := store.Get(deviceKey, serviceKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found {
+		t.Error("unexpected value for", deviceKey, serviceKey)
+	}
+}
+
+func testRecord(deviceKey string, serviceKey string, value []byte) model.Record {
+	now := time.Now()
+	return model.Record{DeviceKey: deviceKey, ServiceKey: serviceKey, Value: value, Time: now, Received: now}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage"
+	"log"
+	"os"
+)
+
+type snapshot struct {
+	Values  []model.Record   `json:"values"`
+	History [][]model.Record `json:"history"`
+}
+
+// snapshot writes all values to a temporary file, which replaces the snapshot file afterwards,
+// so a crash while writing never corrupts the last snapshot. unchanged values are not written again.
+func (this *Store) snapshot() error {
+	this.mux.Lock()
+	if !this.changed {
+		this.mux.Unlock()
+		return nil
+	}
+	temp := snapshot{}
+	for _, record := range this.values {
+		temp.Values = append(temp.Values, record)
+	}
+	for _, list := range this.history {
+		temp.History = append(temp.History, list)
+	}
+	this.changed = false
+	this.mux.Unlock()
+
+	err := this.writeSnapshot(temp)
+	if err != nil {
+		this.mux.Lock()
+		this.changed = true
+		this.mux.Unlock()
+	}
+	return err
+}
+
+func (this *Store) writeSnapshot(temp snapshot) error {
+	file, err := os.Create(this.location + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(file).Encode(temp)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(this.location+".tmp", this.location)
+}
+
+func (this *Store) loadSnapshot() error {
+	file, err := os.Open(this.location)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	temp := snapshot{}
+	err = json.NewDecoder(file).Decode(&temp)
+	if err != nil {
+		return storage.Corrupt(errors.New("unable to read memory snapshot " + this.location + ": " + err.Error()))
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for _, record := range temp.Values {
+		this.values[key{deviceKey: record.DeviceKey, serviceKey: record.ServiceKey}] = record
+	}
+	for _, list := range temp.History {
+		if len(list) > 0 {
+			this.history[key{deviceKey: list[0].DeviceKey, serviceKey: list[0].ServiceKey}] = list
+		}
+	}
+	log.Println("loaded", len(temp.Values), "values from memory snapshot")
+	return nil
+}
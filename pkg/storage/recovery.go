@@ -0,0 +1,88 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrCorrupt marks errors of backends that can not open their database because it is damaged (see Corrupt)
+var ErrCorrupt = errors.New("database is corrupt")
+
+// Corrupt marks err as caused by a damaged database; other errors (e.g. invalid config, wrong encryption key) never trigger a recovery
+func Corrupt(err error) error {
+	return fmt.Errorf("%w: %w", ErrCorrupt, err)
+}
+
+var files = map[string]func(config configuration.Config) []string{}
+
+// RegisterFiles registers the function returning the database files or directories of a backend;
+// NewWithRecovery moves these files aside if the backend reports ErrCorrupt.
+func RegisterFiles(name string, f func(config configuration.Config) []string) {
+	backendsMux.Lock()
+	defer backendsMux.Unlock()
+	files[name] = f
+}
+
+// NewWithRecovery is NewWithConfig, but if config.StorageRecovery is set and the database is corrupt,
+// the database files are moved aside with a timestamped name and the backend is started with a fresh database.
+// recovery describes the moved files; it is nil if no recovery was necessary.
+func NewWithRecovery(ctx context.Context, wg *sync.WaitGroup, config configuration.Config) (result Storage, recovery *model.Recovery, err error) {
+	name := Selection(config)
+	result, err = New(ctx, wg, name, config)
+	if err == nil || !config.StorageRecovery || !errors.Is(err, ErrCorrupt) {
+		return result, nil, err
+	}
+	log.Println("ERROR: unable to open", name, "storage:", err)
+	recovery = &model.Recovery{
+		Time:    time.Now(),
+		Backend: name,
+		Error:   err.Error(),
+	}
+	backendsMux.RLock()
+	locations := files[name]
+	backendsMux.RUnlock()
+	if locations == nil {
+		return result, recovery, errors.New("unable to recover " + name + " storage: database files unknown: " + err.Error())
+	}
+	suffix := ".corrupt-" + recovery.Time.Format("20060102T150405")
+	for _, location := range locations(config) {
+		if _, err = os.Stat(location); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		err = os.Rename(location, location+suffix)
+		if err != nil {
+			return result, recovery, errors.New("unable to move corrupt database aside:" + err.Error())
+		}
+		log.Println("WARNING: moved corrupt database", location, "to", location+suffix)
+		recovery.MovedFiles = append(recovery.MovedFiles, location+suffix)
+	}
+	result, err = New(ctx, wg, name, config)
+	if err != nil {
+		return result, recovery, errors.New("unable to start fresh " + name + " storage after recovery:" + err.Error())
+	}
+	log.Println("WARNING: started", name, "storage with a fresh database after recovery")
+	return result, recovery, nil
+}
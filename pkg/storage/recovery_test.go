@@ -0,0 +1,99 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "test.db")
+	Register("recovery-test", func(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, section json.RawMessage) (Storage, error) {
+		content, err := os.ReadFile(location)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch string(content) {
+		case "corrupt":
+			return nil, Corrupt(errors.New("invalid content"))
+		case "locked":
+			return nil, errors.New("locked")
+		}
+		return nil, nil
+	})
+	RegisterFiles("recovery-test", func(config configuration.Config) []string {
+		return []string{location}
+	})
+	defer func() {
+		backendsMux.Lock()
+		delete(backends, "recovery-test")
+		delete(files, "recovery-test")
+		backendsMux.Unlock()
+	}()
+	config := configuration.Config{StorageSelection: "recovery-test", StorageRecovery: true}
+
+	_, recovery, err := NewWithRecovery(context.Background(), nil, config)
+	if err != nil || recovery != nil {
+		t.Error(recovery, err)
+	}
+
+	err = os.WriteFile(location, []byte("locked"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, recovery, err = NewWithRecovery(context.Background(), nil, config)
+	if err == nil || recovery != nil {
+		t.Error("only corrupt databases should be recovered", recovery, err)
+	}
+
+	err = os.WriteFile(location, []byte("corrupt"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.StorageRecovery = false
+	_, recovery, err = NewWithRecovery(context.Background(), nil, config)
+	if !errors.Is(err, ErrCorrupt) || recovery != nil {
+		t.Error("recovery is disabled", recovery, err)
+	}
+
+	config.StorageRecovery = true
+	_, recovery, err = NewWithRecovery(context.Background(), nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovery == nil || len(recovery.MovedFiles) != 1 || !strings.HasPrefix(recovery.MovedFiles[0], location+".corrupt-") || !strings.Contains(recovery.Error, "invalid content") {
+		t.Fatal(recovery)
+	}
+	content, err := os.ReadFile(recovery.MovedFiles[0])
+	if err != nil || string(content) != "corrupt" {
+		t.Error(string(content), err)
+	}
+	if _, err = os.Stat(location); !errors.Is(err, os.ErrNotExist) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates a backend; section is the backend specific part of config.StorageConfig (nil if not configured)
+type Factory func(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, section json.RawMessage) (Storage, error)
+
+var backends = map[string]Factory{}
+var backendsMux sync.RWMutex
+
+// Register makes a backend available as storage_selection value; backends register themselves in init()
+// and are enabled by importing their package. Register panics if the name is already used.
+func Register(name string, factory Factory) {
+	backendsMux.Lock()
+	defer backendsMux.Unlock()
+	if factory == nil {
+		panic("storage: Register factory is nil for " + name)
+	}
+	if _, exists := backends[name]; exists {
+		panic("storage: Register called twice for " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends
+func Backends() (result []string) {
+	backendsMux.RLock()
+	defer backendsMux.RUnlock()
+	for name := range backends {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// New creates the registered backend with the given name
+func New(ctx context.Context, wg *sync.WaitGroup, name string, config configuration.Config) (result Storage, err error) {
+	backendsMux.RLock()
+	factory, ok := backends[name]
+	backendsMux.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown storage backend " + name + "; available: " + strings.Join(Backends(), ", "))
+	}
+	return factory(ctx, wg, config, config.StorageConfig[name])
+}
+
+// ApplySection returns config with the flat keys of a built-in backend overridden by its config section:
+// section keys are the flat keys without the name prefix, e.g. {"location": "/data/last_value.db"} in the "bolt"
+// section overrides bolt_location. keys missing in the section keep their flat value; unknown keys are rejected.
+func ApplySection(config configuration.Config, name string, section json.RawMessage) (result configuration.Config, err error) {
+	if len(section) == 0 {
+		return config, nil
+	}
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(section, &fields)
+	if err != nil {
+		return config, errors.New("invalid storage_config section " + name + ": " + err.Error())
+	}
+	prefixed := map[string]json.RawMessage{}
+	for key, value := range fields {
+		prefixed[name+"_"+key] = value
+	}
+	encoded, err := json.Marshal(prefixed)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	result = config
+	err = decoder.Decode(&result)
+	if err != nil {
+		return config, errors.New("invalid storage_config section " + name + " (keys are the " + name + "_* config keys without prefix): " + err.Error())
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var receivedSection json.RawMessage
+	Register("test", func(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, section json.RawMessage) (Storage, error) {
+		receivedSection = section
+		return nil, nil
+	})
+	defer func() {
+		backendsMux.Lock()
+		delete(backends, "test")
+		backendsMux.Unlock()
+	}()
+
+	config := configuration.Config{
+		StorageSelection: "test",
+		StorageConfig:    map[string]json.RawMessage{"test": json.RawMessage(`{"foo":"bar"}`)},
+	}
+	_, err := NewWithConfig(context.Background(), nil, config)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(receivedSection) != `{"foo":"bar"}` {
+		t.Error(string(receivedSection))
+	}
+
+	config.StorageSelection = "unknown"
+	_, err = NewWithConfig(context.Background(), nil, config)
+	if err == nil || !strings.Contains(err.Error(), "available: test") {
+		t.Error(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic on duplicate registration")
+			}
+		}()
+		Register("test", func(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, section json.RawMessage) (Storage, error) {
+			return nil, nil
+		})
+	}()
+}
+
+func TestApplySection(t *testing.T) {
+	config := configuration.Config{BoltLocation: "flat.db", BoltTtlSweepInterval: "1h", BoltWriteBufferSize: 10}
+	result, err := ApplySection(config, "bolt", json.RawMessage(`{"location":"section.db","write_buffer_size":100}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BoltLocation != "section.db" || result.BoltWriteBufferSize != 100 || result.BoltTtlSweepInterval != "1h" {
+		t.Error(result.BoltLocation, result.BoltWriteBufferSize, result.BoltTtlSweepInterval)
+	}
+	result, err = ApplySection(config, "bolt", nil)
+	if err != nil || result.BoltLocation != "flat.db" {
+		t.Error(result.BoltLocation, err)
+	}
+	for _, invalid := range []string{`{"unknown":1}`, `{"location":1}`, `[]`} {
+		_, err = ApplySection(config, "bolt", json.RawMessage(invalid))
+		if err == nil {
+			t.Error("expected error for", invalid)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// availableMemory returns MemAvailable of /proc/meminfo, limited by the memory limit of the cgroup
+func availableMemory() (result uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	found := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, errors.New("unable to parse MemAvailable:" + err.Error())
+			}
+			result, found = kb*1024, true
+			break
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.New("missing MemAvailable in /proc/meminfo")
+	}
+	if limit, ok := cgroupMemoryLimit(); ok && limit < result {
+		result = limit
+	}
+	return result, nil
+}
+
+// cgroupMemoryLimit returns the memory limit of cgroup v2 or v1; ok is false if no limit is set
+func cgroupMemoryLimit() (limit uint64, ok bool) {
+	for _, location := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		content, err := os.ReadFile(location)
+		if err != nil {
+			continue
+		}
+		//"max" (v2) and values near max int64 (v1) mean unlimited
+		limit, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil || limit >= 1<<62 {
+			return 0, false
+		}
+		return limit, true
+	}
+	return 0, false
+}
+
+func freeDisk(location string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(location, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -19,31 +19,50 @@ package storage
 import (
 	"context"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
-	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/badger"
-	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/bolt"
-	"runtime"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"log"
 	"sync"
 	"time"
 )
 
 type Storage interface {
-	Set(key string, value []byte) error
-	Get(key string) (value []byte, time *time.Time, err error)
+	Set(record model.Record) error
+	SetIfNewer(record model.Record) (stored bool, err error)
+	Touch(deviceKey string, serviceKey string, seen time.Time) error
+	Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error)
+	Scan(deviceKey string, handler func(record model.Record) error) error
+	ScanTimes(deviceKey string, handler func(record model.Record) error) error
+	History(deviceKey string, serviceKey string, since time.Time, limit int) (result []model.Record, err error)
+	Delete(deviceKey string, serviceKey string) (deleted bool, err error)
+	DeletePrefix(deviceKey string) (deleted int, err error)
 }
 
+// StatsProvider is implemented by backends that report statistics
+type StatsProvider interface {
+	Stats() (model.StorageStats, error)
+}
+
+// Maintainer is implemented by backends with a manual maintenance operation (badger value log gc, bolt compaction)
+type Maintainer interface {
+	Maintain() (model.MaintenanceResult, error)
+}
+
+// NewWithConfig creates the backend selected by config.StorageSelection (see Selection) from the registered backends.
 func NewWithConfig(ctx context.Context, wg *sync.WaitGroup, config configuration.Config) (result Storage, err error) {
+	return New(ctx, wg, Selection(config), config)
+}
+
+// Selection returns the backend name selected by config.StorageSelection.
+// "auto" selects an existing database or otherwise chooses by available resources (see autoSelection);
+// an empty selection selects badger.
+func Selection(config configuration.Config) string {
 	switch config.StorageSelection {
-	case "bolt":
-		return bolt.NewWithConfig(ctx, wg, config)
-	case "badger":
-		return badger.NewWithConfig(ctx, wg, config)
+	case "":
+		return "badger"
 	case "auto":
-		if runtime.GOARCH == "arm" || runtime.GOARCH == "arm64" || runtime.GOARCH == "armbe" || runtime.GOARCH == "arm64be" {
-			return bolt.NewWithConfig(ctx, wg, config)
-		} else {
-			return badger.NewWithConfig(ctx, wg, config)
-		}
-	default:
-		return badger.NewWithConfig(ctx, wg, config)
+		backend, reason := autoSelection(config, detectCapabilities)
+		log.Println("auto storage selection:", backend+";", reason)
+		return backend
 	}
+	return config.StorageSelection
 }
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimeFormatRFC3339 = "rfc3339"
+	TimeFormatUnix    = "unix"
+	TimeFormatUnixMs  = "unix_ms"
+	TimeFormatUnixNs  = "unix_ns"
+)
+
+func validTimeFormat(format string) bool {
+	switch format {
+	case "", TimeFormatRFC3339, TimeFormatUnix, TimeFormatUnixMs, TimeFormatUnixNs:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseTimestamp reads the timestamp at path (dot separated, like the paths of KeyValueMapperImpl) from the json payload.
+// with an empty format, strings are parsed as RFC3339 and numbers as unix seconds.
+func parseTimestamp(payload []byte, path string, format string) (result time.Time, err error) {
+	value, err := decodeJson(payload)
+	if err != nil {
+		return result, err
+	}
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				value = v[part]
+			case []interface{}:
+				index, err := strconv.Atoi(part)
+				if err != nil || index < 0 || index >= len(v) {
+					return result, errors.New("time path " + path + " not found")
+				}
+				value = v[index]
+			default:
+				return result, errors.New("time path " + path + " not found")
+			}
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		if format == "" || format == TimeFormatRFC3339 {
+			return time.Parse(time.RFC3339Nano, v)
+		}
+		return unixToTime(json.Number(v), format)
+	case json.Number:
+		if format == TimeFormatRFC3339 {
+			return result, errors.New("expected RFC3339 string at time path " + path)
+		}
+		return unixToTime(v, format)
+	default:
+		return result, errors.New("time path " + path + " not found or invalid")
+	}
+}
+
+func unixToTime(value json.Number, format string) (result time.Time, err error) {
+	if i, err := value.Int64(); err == nil {
+		switch format {
+		case TimeFormatUnixMs:
+			return time.UnixMilli(i), nil
+		case TimeFormatUnixNs:
+			return time.Unix(0, i), nil
+		default:
+			return time.Unix(i, 0), nil
+		}
+	}
+	f, err := value.Float64()
+	if err != nil {
+		return result, errors.New("invalid unix timestamp " + value.String())
+	}
+	switch format {
+	case TimeFormatUnixMs:
+		return time.UnixMicro(int64(math.Round(f * 1e3))), nil
+	case TimeFormatUnixNs:
+		return time.Unix(0, int64(f)), nil
+	default:
+		seconds, fraction := math.Modf(f)
+		return time.Unix(int64(seconds), int64(math.Round(fraction*1e9))), nil
+	}
+}
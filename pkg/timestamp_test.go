@@ -0,0 +1,69 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2024, 3, 1, 12, 30, 15, 250000000, time.UTC)
+	tests := []struct {
+		payload string
+		path    string
+		format  string
+	}{
+		{`{"time": "2024-03-01T12:30:15.25Z"}`, "time", ""},
+		{`{"time": "2024-03-01T13:30:15.25+01:00"}`, "time", TimeFormatRFC3339},
+		{`{"meta": {"ts": 1709296215.25}}`, "meta.ts", ""},
+		{`{"meta": {"ts": 1709296215.25}}`, "meta.ts", TimeFormatUnix},
+		{`{"list": [0, 1709296215250]}`, "list.1", TimeFormatUnixMs},
+		{`{"ts": "1709296215250"}`, "ts", TimeFormatUnixMs},
+		{`{"ts": 1709296215250000000}`, "ts", TimeFormatUnixNs},
+		{`1709296215250`, "", TimeFormatUnixMs},
+	}
+	for _, test := range tests {
+		actual, err := parseTimestamp([]byte(test.payload), test.path, test.format)
+		if err != nil {
+			t.Error(test, err)
+			continue
+		}
+		if !actual.Equal(expected) {
+			t.Error(test, actual)
+		}
+	}
+
+	for _, invalid := range []struct {
+		payload string
+		path    string
+		format  string
+	}{
+		{`{"time": "foo"}`, "time", ""},
+		{`{"time": 42}`, "time", TimeFormatRFC3339},
+		{`{"time": 42}`, "unknown", ""},
+		{`{"time": 42}`, "time.foo", ""},
+		{`{"time": {}}`, "time", ""},
+		{`[1]`, "1", ""},
+		{`foo`, "", ""},
+	} {
+		_, err := parseTimestamp([]byte(invalid.payload), invalid.path, invalid.format)
+		if err == nil {
+			t.Error("expected error for", invalid)
+		}
+	}
+}
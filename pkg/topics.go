@@ -0,0 +1,202 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"errors"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"strings"
+)
+
+const (
+	PayloadRaw      = "raw"
+	PayloadResponse = "response"
+)
+
+// DefaultTopicTemplates are used if no topic_templates are configured; they match the event and response topics of the platform
+var DefaultTopicTemplates = []configuration.TopicTemplate{
+	{Template: "event/{device}/{service}", Payload: PayloadRaw},
+	{Template: "response/{device}/{service}", Payload: PayloadResponse},
+}
+
+const (
+	devicePlaceholder  = "device"
+	servicePlaceholder = "service"
+)
+
+// TopicTemplate matches mqtt topics and extracts device and service keys.
+// template levels are separated by '/' and may be:
+//   - a literal, which has to match exactly
+//   - a placeholder like {device}, {service} or {any_name}, which matches one level
+//   - a multi-level placeholder like {service...} as last level, which matches all remaining levels (joined by '/')
+//   - '#' as last level, which matches all remaining levels without capturing them
+//
+// {device} and {service} are required; e.g. "event/{device}/{service}" or "custom/{device}/{service...}"
+type TopicTemplate struct {
+	Template     string
+	Subscription string
+	Payload      string
+	TimePath     string
+	TimeFormat   string
+	levels       []templateLevel
+}
+
+type templateLevel struct {
+	literal     string
+	placeholder string
+	multi       bool
+}
+
+func ParseTopicTemplate(template configuration.TopicTemplate) (result TopicTemplate, err error) {
+	result = TopicTemplate{
+		Template:   template.Template,
+		Payload:    template.Payload,
+		TimePath:   template.TimePath,
+		TimeFormat: template.TimeFormat,
+	}
+	if result.Payload == "" {
+		result.Payload = PayloadRaw
+	}
+	if result.Payload != PayloadRaw && result.Payload != PayloadResponse {
+		return result, errors.New("invalid payload handling '" + template.Payload + "' in topic template " + template.Template)
+	}
+	if !validTimeFormat(result.TimeFormat) {
+		return result, errors.New("invalid time format '" + template.TimeFormat + "' in topic template " + template.Template)
+	}
+	placeholders, err := result.parseLevels()
+	if err != nil {
+		return result, err
+	}
+	if !placeholders[devicePlaceholder] || !placeholders[servicePlaceholder] {
+		return result, errors.New("missing {device} or {service} placeholder in topic template " + template.Template)
+	}
+	return result, nil
+}
+
+// ParseClearTopicTemplate parses the template of topics that clear stored values (see configuration.Config.ClearTopicTemplate).
+// {device} is required; without {service}, matching topics clear all services of the device.
+func ParseClearTopicTemplate(template string) (result TopicTemplate, err error) {
+	result = TopicTemplate{Template: template}
+	placeholders, err := result.parseLevels()
+	if err != nil {
+		return result, err
+	}
+	if !placeholders[devicePlaceholder] {
+		return result, errors.New("missing {device} placeholder in clear topic template " + template)
+	}
+	return result, nil
+}
+
+// parseLevels sets levels and Subscription of the template and returns the names of its placeholders
+func (this *TopicTemplate) parseLevels() (placeholders map[string]bool, err error) {
+	parts := strings.Split(this.Template, "/")
+	subscription := []string{}
+	placeholders = map[string]bool{}
+	for i, part := range parts {
+		last := i == len(parts)-1
+		level := templateLevel{literal: part}
+		switch {
+		case part == "#":
+			if !last {
+				return placeholders, errors.New("'#' is only allowed as last level in topic template " + this.Template)
+			}
+			level = templateLevel{multi: true}
+			subscription = append(subscription, "#")
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+			multi := strings.HasSuffix(name, "...")
+			name = strings.TrimSuffix(name, "...")
+			if multi && !last {
+				return placeholders, errors.New("multi-level placeholder is only allowed as last level in topic template " + this.Template)
+			}
+			if name == "" || placeholders[name] {
+				return placeholders, errors.New("empty or duplicate placeholder in topic template " + this.Template)
+			}
+			placeholders[name] = true
+			level = templateLevel{placeholder: name, multi: multi}
+			if multi {
+				subscription = append(subscription, "#")
+			} else {
+				subscription = append(subscription, "+")
+			}
+		case strings.ContainsAny(part, "{}+#"):
+			return placeholders, errors.New("invalid level '" + part + "' in topic template " + this.Template)
+		default:
+			subscription = append(subscription, part)
+		}
+		this.levels = append(this.levels, level)
+	}
+	this.Subscription = strings.Join(subscription, "/")
+	return placeholders, nil
+}
+
+// Match returns the device and service keys of a topic matching the template; empty keys are not accepted
+func (this TopicTemplate) Match(topic string) (deviceKey string, serviceKey string, ok bool) {
+	values, ok := this.match(topic)
+	if !ok {
+		return "", "", false
+	}
+	deviceKey, serviceKey = values[devicePlaceholder], values[servicePlaceholder]
+	if deviceKey == "" || serviceKey == "" {
+		return "", "", false
+	}
+	return deviceKey, serviceKey, true
+}
+
+// MatchClear is Match for clear topic templates; serviceKey is empty if the template has no {service} placeholder
+func (this TopicTemplate) MatchClear(topic string) (deviceKey string, serviceKey string, ok bool) {
+	values, ok := this.match(topic)
+	if !ok {
+		return "", "", false
+	}
+	deviceKey, serviceKey = values[devicePlaceholder], values[servicePlaceholder]
+	_, hasService := values[servicePlaceholder]
+	if deviceKey == "" || hasService && serviceKey == "" {
+		return "", "", false
+	}
+	return deviceKey, serviceKey, true
+}
+
+// match returns the placeholder values of a topic matching the template
+func (this TopicTemplate) match(topic string) (values map[string]string, ok bool) {
+	parts := strings.Split(topic, "/")
+	values = map[string]string{}
+	for i, level := range this.levels {
+		if level.multi {
+			if level.placeholder != "" {
+				if i >= len(parts) {
+					return nil, false
+				}
+				values[level.placeholder] = strings.Join(parts[i:], "/")
+			}
+			parts = parts[:i]
+			break
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if level.placeholder != "" {
+			values[level.placeholder] = parts[i]
+		} else if level.literal != parts[i] {
+			return nil, false
+		}
+	}
+	if len(parts) > len(this.levels) {
+		return nil, false
+	}
+	return values, true
+}
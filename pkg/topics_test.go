@@ -0,0 +1,160 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"testing"
+)
+
+func TestTopicTemplates(t *testing.T) {
+	type match struct {
+		topic   string
+		device  string
+		service string
+		ok      bool
+	}
+	tests := map[string]struct {
+		subscription string
+		matches      []match
+	}{
+		"event/{device}/{service}": {"event/+/+", []match{
+			{"event/d1/s1", "d1", "s1", true},
+			{"event/d.1/s.1", "d.1", "s.1", true},
+			{"event/d1/s1/sub", "", "", false},
+			{"event/d1", "", "", false},
+			{"event/d1/", "", "", false},
+			{"response/d1/s1", "", "", false},
+		}},
+		"event/{device}/{service}/#": {"event/+/+/#", []match{
+			{"event/d1/s1", "d1", "s1", true},
+			{"event/d1/s1/sub/sub2", "d1", "s1", true},
+		}},
+		"prefix/event/{device}/{service...}": {"prefix/event/+/#", []match{
+			{"prefix/event/d1/s1", "d1", "s1", true},
+			{"prefix/event/d1/s1/sub", "d1", "s1/sub", true},
+			{"prefix/event/d1", "", "", false},
+		}},
+		"{connector}/{service}/{device}": {"+/+/+", []match{
+			{"zigbee/s1/d1", "d1", "s1", true},
+		}},
+	}
+	for template, test := range tests {
+		parsed, err := ParseTopicTemplate(configuration.TopicTemplate{Template: template})
+		if err != nil {
+			t.Error(template, err)
+			continue
+		}
+		if parsed.Subscription != test.subscription || parsed.Payload != PayloadRaw {
+			t.Error(template, parsed.Subscription, parsed.Payload)
+		}
+		for _, m := range test.matches {
+			device, service, ok := parsed.Match(m.topic)
+			if device != m.device || service != m.service || ok != m.ok {
+				t.Error(template, m, device, service, ok)
+			}
+		}
+	}
+
+	for _, invalid := range []configuration.TopicTemplate{
+		{Template: "event/{device}"},
+		{Template: "event/{device}/{device}/{service}"},
+		{Template: "event/#/{device}/{service}"},
+		{Template: "event/{device...}/{service}"},
+		{Template: "event/+/{device}/{service}"},
+		{Template: "event/{}/{device}/{service}"},
+		{Template: "event/{device}/{service}", Payload: "unknown"},
+	} {
+		_, err := ParseTopicTemplate(invalid)
+		if err == nil {
+			t.Error("expected error for", invalid)
+		}
+	}
+}
+
+func TestClearTopicTemplates(t *testing.T) {
+	type match struct {
+		topic   string
+		device  string
+		service string
+		ok      bool
+	}
+	tests := map[string]struct {
+		subscription string
+		matches      []match
+	}{
+		"device-removed/{device}": {"device-removed/+", []match{
+			{"device-removed/d1", "d1", "", true},
+			{"device-removed/", "", "", false},
+			{"device-removed/d1/s1", "", "", false},
+		}},
+		"value-removed/{device}/{service...}": {"value-removed/+/#", []match{
+			{"value-removed/d1/s1", "d1", "s1", true},
+			{"value-removed/d1/s1/sub", "d1", "s1/sub", true},
+			{"value-removed/d1/", "", "", false},
+		}},
+	}
+	for template, test := range tests {
+		parsed, err := ParseClearTopicTemplate(template)
+		if err != nil {
+			t.Error(template, err)
+			continue
+		}
+		if parsed.Subscription != test.subscription {
+			t.Error(template, parsed.Subscription)
+		}
+		for _, m := range test.matches {
+			device, service, ok := parsed.MatchClear(m.topic)
+			if device != m.device || service != m.service || ok != m.ok {
+				t.Error(template, m, device, service, ok)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"device-removed/{service}", "device-removed/#/{device}", "device-removed/{device}/{device}"} {
+		_, err := ParseClearTopicTemplate(invalid)
+		if err == nil {
+			t.Error("expected error for", invalid)
+		}
+	}
+}
+
+func TestDefaultTopicTemplates(t *testing.T) {
+	templates, clear, err := parseTopicTemplates(configuration.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 || clear != nil {
+		t.Fatal(templates, clear)
+	}
+	for i, expected := range []struct {
+		subscription string
+		payload      string
+	}{{"event/+/+", PayloadRaw}, {"response/+/+", PayloadResponse}} {
+		if templates[i].Subscription != expected.subscription || templates[i].Payload != expected.payload {
+			t.Error(templates[i], expected)
+		}
+	}
+
+	templates, _, err = parseTopicTemplates(configuration.Config{TopicTemplates: []configuration.TopicTemplate{{Template: "custom/{device}/{service...}"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].Subscription != "custom/+/#" {
+		t.Error(templates)
+	}
+}
@@ -19,72 +19,230 @@ package pkg
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
 	"github.com/SENERGY-Platform/mgw-last-value/pkg/mqtt"
 	"log"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Storage interface {
-	Set(key string, value []byte) error
-	Get(key string) (value []byte, time *time.Time, err error)
+	Set(record model.Record) error
+	SetIfNewer(record model.Record) (stored bool, err error)
+	Touch(deviceKey string, serviceKey string, seen time.Time) error
+	Get(deviceKey string, serviceKey string) (record model.Record, found bool, err error)
+	Scan(deviceKey string, handler func(record model.Record) error) error
+	ScanTimes(deviceKey string, handler func(record model.Record) error) error
+	History(deviceKey string, serviceKey string, since time.Time, limit int) (result []model.Record, err error)
+	Delete(deviceKey string, serviceKey string) (deleted bool, err error)
+	DeletePrefix(deviceKey string) (deleted int, err error)
 }
 
-func Worker(ctx context.Context, config configuration.Config, storage Storage) error {
+// Expirer is implemented by storages that expire values by the times of the records instead of the time of the write
+// (see bolt.Store.Expired); writes of expired records are not visible, so Import and Migrate skip them.
+type Expirer interface {
+	Expired(record model.Record) bool
+}
+
+// expired returns true if store implements Expirer and the record is expired
+func expired(store interface{}, record model.Record) bool {
+	expirer, ok := store.(Expirer)
+	return ok && expirer.Expired(record)
+}
+
+// Worker subscribes to the configured topics; wg (may be nil) is done when all received messages are stored after ctx is done
+func Worker(ctx context.Context, wg *sync.WaitGroup, config configuration.Config, storage Storage) error {
+	templates, clear, err := parseTopicTemplates(config)
+	if err != nil {
+		return err
+	}
+	filter, err := NewWriteFilter(config, KeyValueMapperImpl{Debug: config.Debug})
+	if err != nil {
+		return err
+	}
 	client, err := mqtt.New(ctx, config.MqttBroker, config.MqttClientId, config.MqttUser, config.MqttPw)
 	if err != nil {
 		return err
 	}
-	err = client.Subscribe("event/#", 2, func(topic string, payload []byte) {
-		topicParts := strings.Split(topic, "/")
-		if len(topicParts) != 3 {
-			log.Println("WARNING: consumed invalid event topic", topic)
+	dispatcher := NewKeyDispatcher(ctx, wg, int(config.IngestWorkers), 100)
+	for i, template := range templates {
+		err = client.Subscribe(template.Subscription, 2, getMessageHandler(config, storage, filter, dispatcher, template, templates[:i], clear))
+		if err != nil {
+			return err
+		}
+	}
+	if clear != nil {
+		err = client.Subscribe(clear.Subscription, 2, getClearHandler(config, storage, dispatcher, *clear))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTopicTemplates returns the configured topic templates (DefaultTopicTemplates if none are configured)
+// and the clear topic template, which is nil if it is not configured
+func parseTopicTemplates(config configuration.Config) (templates []TopicTemplate, clear *TopicTemplate, err error) {
+	configured := config.TopicTemplates
+	if len(configured) == 0 {
+		configured = DefaultTopicTemplates
+	}
+	subscriptions := map[string]bool{}
+	for _, t := range configured {
+		template, err := ParseTopicTemplate(t)
+		if err != nil {
+			return templates, clear, err
+		}
+		if subscriptions[template.Subscription] {
+			return templates, clear, errors.New("multiple topic templates with the same subscription " + template.Subscription)
+		}
+		subscriptions[template.Subscription] = true
+		templates = append(templates, template)
+	}
+	if config.ClearTopicTemplate != "" {
+		template, err := ParseClearTopicTemplate(config.ClearTopicTemplate)
+		if err != nil {
+			return templates, clear, err
+		}
+		if subscriptions[template.Subscription] {
+			return templates, clear, errors.New("clear topic template has the same subscription as a topic template " + template.Subscription)
+		}
+		clear = &template
+	}
+	return templates, clear, nil
+}
+
+// getMessageHandler returns the handler of a topic template.
+// overlapping subscriptions deliver messages to every matching handler; such messages are only
+// handled by the first matching template, so precedingTemplates are checked too.
+// messages are stored by the dispatcher, which keeps the order of messages and clears per device;
+// additionally values are only replaced by values with a newer time (see Storage.SetIfNewer).
+// topics matching the clear template (may be nil) are left to the clear handler.
+func getMessageHandler(config configuration.Config, storage Storage, filter *WriteFilter, dispatcher *KeyDispatcher, template TopicTemplate, precedingTemplates []TopicTemplate, clear *TopicTemplate) func(topic string, payload []byte) {
+	return func(topic string, payload []byte) {
+		deviceKey, serviceKey, ok := template.Match(topic)
+		if !ok {
+			if config.Debug {
+				log.Println("DEBUG: topic", topic, "does not match template", template.Template)
+			}
 			return
 		}
-		deviceKey := topicParts[1]
-		serviceKey := topicParts[2]
-		key := deviceKey + "." + serviceKey
-		if config.Debug {
-			log.Println("DEBUG: store", key, string(payload))
+		for _, preceding := range precedingTemplates {
+			if _, _, match := preceding.Match(topic); match {
+				return
+			}
 		}
-		err = storage.Set(key, payload)
-		if len(topicParts) != 3 {
-			log.Println("ERROR: unable to store value", err)
+		if clear != nil {
+			if _, _, match := clear.MatchClear(topic); match {
+				return
+			}
 		}
-	})
-	if err != nil {
-		return err
+		now := time.Now()
+		dispatcher.Dispatch(deviceKey, func() {
+			store(config, storage, filter, template, topic, deviceKey, serviceKey, payload, now)
+		})
 	}
-	err = client.Subscribe("response/#", 2, func(topic string, response []byte) {
-		topicParts := strings.Split(topic, "/")
-		if len(topicParts) != 3 {
-			log.Println("WARNING: consumed invalid event topic", topic)
+}
+
+// getClearHandler returns the handler of the clear topic template. only empty payloads (like the removal of a retained message)
+// delete values; clears are dispatched with the device key like the values, so they keep their order.
+func getClearHandler(config configuration.Config, storage Storage, dispatcher *KeyDispatcher, template TopicTemplate) func(topic string, payload []byte) {
+	return func(topic string, payload []byte) {
+		deviceKey, serviceKey, ok := template.MatchClear(topic)
+		if !ok {
+			if config.Debug {
+				log.Println("DEBUG: topic", topic, "does not match clear template", template.Template)
+			}
+			return
+		}
+		if len(payload) > 0 {
+			if config.Debug {
+				log.Println("DEBUG: ignore non empty message in clear topic", topic)
+			}
+			return
+		}
+		if serviceKey == "" {
+			dispatcher.Dispatch(deviceKey, func() {
+				clearDevice(config, storage, deviceKey)
+			})
 			return
 		}
-		deviceKey := topicParts[1]
-		serviceKey := topicParts[2]
-		key := deviceKey + "." + serviceKey
+		dispatcher.Dispatch(deviceKey, func() {
+			clearService(config, storage, deviceKey, serviceKey)
+		})
+	}
+}
+
+func clearDevice(config configuration.Config, storage Storage, deviceKey string) {
+	deleted, err := storage.DeletePrefix(deviceKey)
+	if err != nil {
+		log.Println("ERROR: unable to delete values of device", deviceKey, err)
+		return
+	}
+	if config.Debug {
+		log.Println("DEBUG: deleted", deleted, "values of device", deviceKey)
+	}
+}
 
+func clearService(config configuration.Config, storage Storage, deviceKey string, serviceKey string) {
+	deleted, err := storage.Delete(deviceKey, serviceKey)
+	if err != nil {
+		log.Println("ERROR: unable to delete value", deviceKey, serviceKey, err)
+		return
+	}
+	if config.Debug && deleted {
+		log.Println("DEBUG: deleted value", deviceKey, serviceKey)
+	}
+}
+
+func store(config configuration.Config, storage Storage, filter *WriteFilter, template TopicTemplate, topic string, deviceKey string, serviceKey string, payload []byte, now time.Time) {
+	if template.Payload == PayloadResponse {
 		resp := Response{}
-		err = json.Unmarshal(response, &resp)
+		err := json.Unmarshal(payload, &resp)
 		if err != nil {
-			log.Println("WARNING: unexpected message in response topic:", string(response))
+			log.Println("WARNING: unexpected message in response topic:", topic, string(payload))
 			return
 		}
-
-		if config.Debug {
-			log.Println("DEBUG: store", key, resp.Data)
+		payload = []byte(resp.Data)
+	}
+	record := model.Record{DeviceKey: deviceKey, ServiceKey: serviceKey, Value: payload, Time: now, Received: now}
+	if template.TimePath != "" {
+		valueTime, err := parseTimestamp(payload, template.TimePath, template.TimeFormat)
+		if err != nil {
+			log.Println("WARNING: unable to read timestamp from payload --> use receive time:", topic, err)
+		} else {
+			record.Time = valueTime
+			if !record.OrderTime().Equal(valueTime) {
+				log.Println("WARNING: timestamp in payload is in the future --> order by receive time:", topic, valueTime)
+			}
 		}
-		err = storage.Set(key, []byte(resp.Data))
-		if len(topicParts) != 3 {
-			log.Println("ERROR: unable to store value", err)
+	}
+	if filter.Enabled() {
+		current, found, err := storage.Get(deviceKey, serviceKey)
+		if err != nil {
+			log.Println("WARNING: unable to read current value for write suppression --> store value:", deviceKey, serviceKey, err)
+		} else if found && filter.Suppress(current, record, template.TimePath) {
+			if config.Debug {
+				log.Println("DEBUG: suppress unchanged value", deviceKey, serviceKey, string(payload))
+			}
+			err = storage.Touch(deviceKey, serviceKey, record.Received)
+			if err != nil {
+				log.Println("ERROR: unable to update last seen time", err)
+			}
+			return
 		}
-	})
+	}
+	if config.Debug {
+		log.Println("DEBUG: store", deviceKey, serviceKey, record.Time, string(payload))
+	}
+	stored, err := storage.SetIfNewer(record)
 	if err != nil {
-		return err
+		log.Println("ERROR: unable to store value", err)
+	} else if !stored && config.Debug {
+		log.Println("DEBUG: ignore value older than stored value", deviceKey, serviceKey, record.Time)
 	}
-	return nil
 }
 
 type Response struct {
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkg
+
+import (
+	"context"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/configuration"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/model"
+	"github.com/SENERGY-Platform/mgw-last-value/pkg/storage/memory"
+	"testing"
+	"time"
+)
+
+func TestClearHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := memory.New(ctx, nil, "", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"d1", "s1"}, {"d1", "s2"}, {"d2", "s1"}, {"d2", "s2"}} {
+		err = store.Set(testRecord(key[0], key[1], []byte("42")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dispatcher := NewKeyDispatcher(ctx, nil, 2, 10)
+	//tasks of the same key run in order, so a following task waits for the clear
+	wait := func(key string) {
+		done := make(chan struct{})
+		dispatcher.Dispatch(key, func() { close(done) })
+		<-done
+	}
+	check := func(deviceKey string, serviceKey string, expected bool) {
+		t.Helper()
+		_, found, err := store.Get(deviceKey, serviceKey)
+		if err != nil || found != expected {
+			t.Error(deviceKey, serviceKey, found, err)
+		}
+	}
+
+	deviceTemplate, err := ParseClearTopicTemplate("device-removed/{device}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := getClearHandler(configuration.Config{}, store, dispatcher, deviceTemplate)
+	handler("device-removed/d1", []byte("not empty"))
+	wait("d1")
+	check("d1", "s1", true)
+	handler("device-removed/d1", nil)
+	wait("d1")
+	check("d1", "s1", false)
+	check("d1", "s2", false)
+	check("d2", "s1", true)
+
+	serviceTemplate, err := ParseClearTopicTemplate("value-removed/{device}/{service}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler = getClearHandler(configuration.Config{}, store, dispatcher, serviceTemplate)
+	handler("value-removed/d2/s1", []byte{})
+	wait("d2")
+	check("d2", "s1", false)
+	check("d2", "s2", true)
+
+	//values and clears of a device run on the same worker, so a device clear waits for a slow preceding write
+	valueTemplate, err := ParseTopicTemplate(configuration.TopicTemplate{Template: "event/{device}/{service...}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewWriteFilter(configuration.Config{}, KeyValueMapperImpl{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valueHandler := getMessageHandler(configuration.Config{}, slowStore{store}, filter, dispatcher, valueTemplate, nil, nil)
+	deviceHandler := getClearHandler(configuration.Config{}, store, dispatcher, deviceTemplate)
+	valueHandler("event/d3/s1", []byte("42"))
+	deviceHandler("device-removed/d3", nil)
+	wait("d3")
+	wait("d3/s1") //"d3" and "d3/s1" use different workers
+	check("d3", "s1", false)
+}
+
+// slowStore delays writes, so that tasks on other workers would overtake them
+type slowStore struct {
+	*memory.Store
+}
+
+func (this slowStore) SetIfNewer(record model.Record) (bool, error) {
+	time.Sleep(50 * time.Millisecond)
+	return this.Store.SetIfNewer(record)
+}